@@ -0,0 +1,314 @@
+package opal
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Filter returns a new Activity holding only the transactions for which pred returns true.
+// The transactions themselves are shared with a.
+func (a *Activity) Filter(pred func(Transaction) bool) *Activity {
+	fa := &Activity{
+		CardName: a.CardName,
+		Notices:  a.Notices,
+	}
+	for _, t := range a.Transactions {
+		if pred(*t) {
+			fa.Transactions = append(fa.Transactions, t)
+		}
+	}
+	return fa
+}
+
+// A TopUp is an addition to a card's balance.
+type TopUp struct {
+	Transaction *Transaction
+	// ReceiptAvailable is whether the site links a tax invoice for the top up,
+	// at the transaction's ReceiptURL.
+	ReceiptAvailable bool
+}
+
+// TopUps returns the top ups in the activity.
+func (a *Activity) TopUps() []TopUp {
+	var tus []TopUp
+	for _, t := range a.Transactions {
+		if t.Amount <= 0 || !strings.HasPrefix(strings.ToLower(t.Details), "top up") {
+			continue
+		}
+		tus = append(tus, TopUp{Transaction: t, ReceiptAvailable: t.ReceiptURL != ""})
+	}
+	return tus
+}
+
+// ErrNoPeriodTotal is returned by Reconciles when the site did not display a total.
+var ErrNoPeriodTotal = errors.New("no period total displayed")
+
+// Reconciles reports whether the transactions in the activity add up
+// to the total displayed by the site. A mismatch suggests a transaction
+// was missed or misparsed.
+// Trips are the transactions with a mode, and the amount spent is the sum of the charges.
+func (a *Activity) Reconciles() (bool, error) {
+	if a.Total == nil {
+		return false, ErrNoPeriodTotal
+	}
+	var got PeriodTotal
+	for _, t := range a.Transactions {
+		if t.Mode != "" {
+			got.Trips++
+		}
+		if t.Amount < 0 {
+			got.Spent -= t.Amount
+		}
+	}
+	return got == *a.Total, nil
+}
+
+// ProjectBalanceDepletion estimates how long a balance of current will last,
+// assuming spending continues at the average daily rate seen in the activity.
+// The rate is averaged over the calendar days from the first to the last transaction.
+func (a *Activity) ProjectBalanceDepletion(current Money) (time.Duration, error) {
+	if len(a.Transactions) == 0 {
+		return 0, errors.New("no transactions to estimate spending from")
+	}
+	var spent Money
+	first, last := a.Transactions[0].When, a.Transactions[0].When
+	for _, t := range a.Transactions {
+		if t.Amount < 0 {
+			spent -= t.Amount
+		}
+		if t.When.Before(first) {
+			first = t.When
+		}
+		if t.When.After(last) {
+			last = t.When
+		}
+	}
+	if spent == 0 {
+		return 0, errors.New("no spending in activity")
+	}
+	if current <= 0 {
+		return 0, nil
+	}
+	days := daysBetween(first, last) + 1
+	perDay := float64(spent) / float64(days)
+	return time.Duration(float64(current) / perDay * float64(24*time.Hour)), nil
+}
+
+// An Anomaly is a suspicious pattern of transactions, such as a possible billing error.
+type Anomaly struct {
+	Kind         AnomalyKind
+	Transactions []*Transaction
+	Reason       string
+}
+
+// AnomalyKind classifies an Anomaly.
+type AnomalyKind int
+
+const (
+	// AnomalyDuplicateCharge is identical charges made within DuplicateChargeWindow of each other.
+	AnomalyDuplicateCharge AnomalyKind = iota
+	// AnomalyExcessiveFare is a single fare, excluding any surcharge,
+	// that is more than the adult daily cap.
+	AnomalyExcessiveFare
+)
+
+func (k AnomalyKind) String() string {
+	switch k {
+	case AnomalyDuplicateCharge:
+		return "duplicate charge"
+	case AnomalyExcessiveFare:
+		return "excessive fare"
+	}
+	return fmt.Sprintf("AnomalyKind(%d)", int(k))
+}
+
+// DuplicateChargeWindow is how close together identical charges must be
+// for Anomalies to report them as duplicates.
+const DuplicateChargeWindow = 5 * time.Minute
+
+// Adult fare caps, used when the site does not show the card's own.
+const (
+	dailyFareCap  Money = 1580 // no single fare should exceed this
+	weeklyFareCap Money = 6320
+)
+
+// Anomalies reports suspicious transactions in the activity.
+// The activity pages do not show the card balance,
+// so balances that have gone negative cannot be detected here.
+func (a *Activity) Anomalies() []Anomaly {
+	var as []Anomaly
+	for i, t := range a.Transactions {
+		if t.Amount >= 0 {
+			continue
+		}
+		for _, u := range a.Transactions[i+1:] {
+			d := t.When.Sub(u.When)
+			if d < 0 {
+				d = -d
+			}
+			if u.Amount == t.Amount && u.Details == t.Details && u.Mode == t.Mode && d <= DuplicateChargeWindow {
+				as = append(as, Anomaly{
+					Kind:         AnomalyDuplicateCharge,
+					Transactions: []*Transaction{t, u},
+					Reason:       fmt.Sprintf("charged %v twice for %q within %v", -t.Amount, t.Details, d),
+				})
+			}
+		}
+		if charge := -t.Amount - t.Surcharge; charge > dailyFareCap {
+			as = append(as, Anomaly{
+				Kind:         AnomalyExcessiveFare,
+				Transactions: []*Transaction{t},
+				Reason:       fmt.Sprintf("charged %v for %q, above the daily cap of %v", charge, t.Details, dailyFareCap),
+			})
+		}
+	}
+	return as
+}
+
+// A Refund pairs a default fare, charged when a trip had no tap off,
+// with the adjustment that later refunded some of it.
+type Refund struct {
+	Charge, Refund *Transaction
+	Net            Money // the overall amount, which is negative for a net charge
+}
+
+// DefaultFareRefunds matches default fares in the activity with their later refunds.
+// A refund matches a charge if it is for the same journey, when both are numbered,
+// and no more than the charge. Each refund is matched at most once.
+// Default fares that have not been refunded are not reported.
+func (a *Activity) DefaultFareRefunds() []Refund {
+	isRefund := func(t *Transaction) bool {
+		if t.Amount <= 0 {
+			return false
+		}
+		s := strings.ToLower(t.Details + " " + t.FareApplied)
+		return strings.Contains(s, "adjustment") || strings.Contains(s, "refund")
+	}
+	used := make(map[*Transaction]bool)
+	var rs []Refund
+	for _, c := range a.Transactions {
+		if c.Amount >= 0 || !strings.EqualFold(c.FareApplied, "Default fare") {
+			continue
+		}
+		var match *Transaction
+		for _, r := range a.Transactions {
+			if used[r] || !isRefund(r) || r.When.Before(c.When) || r.Amount > -c.Amount {
+				continue
+			}
+			if c.JourneyNumber != 0 && r.JourneyNumber != 0 && c.JourneyNumber != r.JourneyNumber {
+				continue
+			}
+			if match == nil || r.When.Before(match.When) {
+				match = r
+			}
+		}
+		if match != nil {
+			used[match] = true
+			rs = append(rs, Refund{Charge: c, Refund: match, Net: c.Amount + match.Amount})
+		}
+	}
+	return rs
+}
+
+// Date returns midnight at the start of the day in Sydney that the transaction belongs to.
+// A trip that crossed midnight belongs to the day it started.
+func (t *Transaction) Date() time.Time { return date(t.When) }
+
+// ByDate groups the activity's transactions by their Date,
+// keeping their order within each day.
+func (a *Activity) ByDate() map[time.Time][]*Transaction {
+	m := make(map[time.Time][]*Transaction)
+	for _, t := range a.Transactions {
+		d := t.Date()
+		m[d] = append(m[d], t)
+	}
+	return m
+}
+
+// A CapHint says whether further travel will be charged, given cap usage.
+type CapHint struct {
+	FreeToday    bool // the daily or weekly cap has been reached
+	FreeThisWeek bool // the weekly cap has been reached
+
+	// RemainingToday and RemainingThisWeek are how much more can be
+	// spent before the daily and weekly caps are reached.
+	RemainingToday, RemainingThisWeek Money
+
+	Message string // a summary, such as "The rest of today's trips are free."
+}
+
+// CapOptimizationHint reports whether more travel today or this week will be charged.
+// Surcharges, such as the airport station access fee, are always charged.
+// It fails if the cap amounts are not known.
+func (cs *CapStatus) CapOptimizationHint() (CapHint, error) {
+	if cs.Daily.Cap == 0 || cs.Weekly.Cap == 0 {
+		return CapHint{}, errors.New("cap amounts not known")
+	}
+	remaining := func(p CapProgress) Money {
+		if p.Reached() {
+			return 0
+		}
+		return p.Cap - p.Spent
+	}
+	h := CapHint{
+		FreeThisWeek:      cs.Weekly.Reached(),
+		RemainingToday:    remaining(cs.Daily),
+		RemainingThisWeek: remaining(cs.Weekly),
+	}
+	h.FreeToday = h.FreeThisWeek || cs.Daily.Reached()
+	if h.RemainingToday > h.RemainingThisWeek {
+		h.RemainingToday = h.RemainingThisWeek // the weekly cap will be reached first
+	}
+	switch {
+	case h.FreeThisWeek:
+		h.Message = "The rest of this week's trips are free."
+	case h.FreeToday:
+		h.Message = "The rest of today's trips are free."
+	default:
+		h.Message = fmt.Sprintf("Trips are charged until another %v is spent today.", h.RemainingToday)
+	}
+	return h, nil
+}
+
+// weekCapUsage computes cap usage for the Opal week that starts at start,
+// from the fares charged in the activity.
+func (a *Activity) weekCapUsage(start time.Time, dailyCap, weeklyCap Money) *WeekCapUsage {
+	wu := &WeekCapUsage{Start: start, End: weekEnd(start), WeeklyCap: weeklyCap}
+	var fares []*Transaction
+	for _, t := range a.Transactions {
+		if t.Amount < 0 && !t.When.Before(wu.Start) && t.When.Before(wu.End) {
+			fares = append(fares, t)
+		}
+	}
+	sort.Slice(fares, func(i, j int) bool { return fares[i].When.Before(fares[j].When) })
+
+	daily := make(map[time.Time]Money)
+	for _, t := range fares {
+		wu.Spent -= t.Amount
+		if wu.CapReachedAt.IsZero() && wu.Spent >= weeklyCap {
+			wu.CapReachedAt = t.When
+		}
+		d := t.Date()
+		before := daily[d]
+		daily[d] -= t.Amount
+		if before < dailyCap && daily[d] >= dailyCap {
+			wu.DailyCapDays = append(wu.DailyCapDays, d)
+		}
+	}
+	return wu
+}
+
+// date returns midnight at the start of t's day in Sydney.
+func date(t time.Time) time.Time {
+	y, m, d := t.In(sydneyZone).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, sydneyZone)
+}
+
+// daysBetween returns the number of calendar days from t0 to t1 in Sydney.
+func daysBetween(t0, t1 time.Time) int {
+	// Round to cope with days that are not 24 hours long due to daylight saving.
+	return int(date(t1).Sub(date(t0)).Hours()/24 + 0.5)
+}
@@ -0,0 +1,275 @@
+package opal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFilter(t *testing.T) {
+	a, err := parseActivity([]byte(activityPage))
+	if err != nil {
+		t.Fatalf("parseActivity: %v", err)
+	}
+	numbers := func(a *Activity) []int {
+		var ns []int
+		for _, t := range a.Transactions {
+			ns = append(ns, t.Number)
+		}
+		return ns
+	}
+
+	trains := a.Filter(func(t Transaction) bool { return t.Mode == ModeTrain })
+	if got, want := numbers(trains), []int{5, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("train transactions = %v, want %v", got, want)
+	}
+	if trains.CardName != a.CardName {
+		t.Errorf("filtered CardName = %q, want %q", trains.CardName, a.CardName)
+	}
+
+	since := time.Date(2015, time.January, 1, 0, 0, 0, 0, sydneyZone)
+	recent := a.Filter(func(t Transaction) bool { return !t.When.Before(since) })
+	if got, want := numbers(recent), []int{6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("transactions since %v = %v, want %v", since, got, want)
+	}
+}
+
+func TestProjectBalanceDepletion(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2015, time.October, d, 8, 0, 0, 0, sydneyZone) }
+
+	// $5 a day for four days.
+	steady := &Activity{Transactions: []*Transaction{
+		{When: day(8), Amount: -500},
+		{When: day(7), Amount: -500},
+		{When: day(6), Amount: 5000}, // top-up; not spending
+		{When: day(6), Amount: -500},
+		{When: day(5), Amount: -500},
+	}}
+	got, err := steady.ProjectBalanceDepletion(2000)
+	if err != nil {
+		t.Fatalf("ProjectBalanceDepletion: %v", err)
+	}
+	if want := 4 * 24 * time.Hour; got != want {
+		t.Errorf("ProjectBalanceDepletion($20) = %v, want %v", got, want)
+	}
+
+	zero := &Activity{Transactions: []*Transaction{
+		{When: day(6), Amount: 5000},
+		{When: day(5), Amount: 0},
+	}}
+	if _, err := zero.ProjectBalanceDepletion(2000); err == nil {
+		t.Errorf("ProjectBalanceDepletion with no spending succeeded, want error")
+	}
+
+	if _, err := new(Activity).ProjectBalanceDepletion(2000); err == nil {
+		t.Errorf("ProjectBalanceDepletion with no transactions succeeded, want error")
+	}
+}
+
+func TestAnomalies(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2015, time.October, 5, h, m, 0, 0, sydneyZone) }
+	charge := &Transaction{Number: 2, When: at(8, 0), Mode: ModeBus, Details: "Chatswood to Wynyard", Amount: -350}
+	repeat := &Transaction{Number: 3, When: at(8, 2), Mode: ModeBus, Details: "Chatswood to Wynyard", Amount: -350}
+	later := &Transaction{Number: 4, When: at(17, 0), Mode: ModeBus, Details: "Chatswood to Wynyard", Amount: -350}
+	huge := &Transaction{Number: 5, When: at(18, 0), Mode: ModeTrain, Details: "Central to Bondi Junction", Amount: -4210}
+	airport := &Transaction{Number: 6, When: at(19, 0), Mode: ModeTrain, Details: "Central to Domestic Airport", Amount: -1780, Surcharge: 1370}
+	topUp := &Transaction{Number: 7, When: at(19, 1), Details: "Top up", Amount: 4000}
+	topUpAgain := &Transaction{Number: 8, When: at(19, 2), Details: "Top up", Amount: 4000}
+
+	a := &Activity{Transactions: []*Transaction{charge, repeat, later, huge, airport, topUp, topUpAgain}}
+	got := a.Anomalies()
+	want := []struct {
+		kind AnomalyKind
+		txns []*Transaction
+	}{
+		{AnomalyDuplicateCharge, []*Transaction{charge, repeat}},
+		{AnomalyExcessiveFare, []*Transaction{huge}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Anomalies returned %d anomalies, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].Kind != w.kind || !reflect.DeepEqual(got[i].Transactions, w.txns) {
+			t.Errorf("anomaly %d is %v of %v, want %v of %v", i, got[i].Kind, got[i].Transactions, w.kind, w.txns)
+		}
+		if got[i].Reason == "" {
+			t.Errorf("anomaly %d has no reason", i)
+		}
+	}
+}
+
+func TestParseMidnightTrip(t *testing.T) {
+	a, err := parseActivity([]byte(activityPageOf(activityRowMidnight, activityRow3)))
+	if err != nil {
+		t.Fatalf("parseActivity: %v", err)
+	}
+	trip := a.Transactions[0]
+	if want := time.Date(2014, time.July, 9, 23, 50, 0, 0, sydneyZone); !trip.When.Equal(want) {
+		t.Errorf("When = %v, want %v", trip.When, want)
+	}
+	if want := time.Date(2014, time.July, 10, 0, 20, 0, 0, sydneyZone); !trip.TapOff.Equal(want) {
+		t.Errorf("TapOff = %v, want %v", trip.TapOff, want)
+	}
+	if !a.Transactions[1].TapOff.IsZero() {
+		t.Errorf("TapOff = %v for transaction without tap off time, want zero", a.Transactions[1].TapOff)
+	}
+
+	days := a.ByDate()
+	july9 := time.Date(2014, time.July, 9, 0, 0, 0, 0, sydneyZone)
+	if len(days) != 1 || len(days[july9]) != 2 {
+		t.Errorf("ByDate = %v, want both transactions on %v", days, july9)
+	}
+}
+
+const activityRowMidnight = `<tr><td>4</td><td class="date-time">Wed<br/>09/07/2014<br/>23:50 - 00:20</td><td class="center"><img alt="train" src="/images/icons/mode-train.png"/></td><td class="transaction-summary">Town Hall to Chatswood</td><td>2</td><td class="right">Off-peak</td><td class="right nowrap">$2.87</td><td class="right nowrap">$0.00</td><td class="right nowrap">-$2.87</td></tr>`
+
+func TestDefaultFareRefunds(t *testing.T) {
+	a, err := parseActivity([]byte(activityPageOf(activityRowAdjustment, activityRowTopUp, activityRowDefault, activityRow3)))
+	if err != nil {
+		t.Fatalf("parseActivity: %v", err)
+	}
+	rs := a.DefaultFareRefunds()
+	if len(rs) != 1 {
+		t.Fatalf("DefaultFareRefunds returned %d refunds, want 1: %+v", len(rs), rs)
+	}
+	r := rs[0]
+	if r.Charge.Number != 5 || r.Refund.Number != 8 {
+		t.Errorf("DefaultFareRefunds matched transaction %d with %d, want 5 with 8", r.Charge.Number, r.Refund.Number)
+	}
+	if r.Net != -287 {
+		t.Errorf("Net = %v, want -$2.87", r.Net)
+	}
+}
+
+const (
+	activityRowDefault    = `<tr><td>5</td><td class="date-time">Wed<br/>09/07/2014<br/>17:01</td><td class="center"><img alt="train" src="/images/icons/mode-train.png"/></td><td class="transaction-summary">Town Hall to No tap off</td><td>2</td><td class="right">Default fare</td><td class="right nowrap">$8.10</td><td class="right nowrap">$0.00</td><td class="right nowrap">-$8.10</td></tr>`
+	activityRowTopUp      = `<tr><td>7</td><td class="date-time">Thu<br/>10/07/2014<br/>08:00</td><td class="center"></td><td class="transaction-summary">Top up</td><td></td><td class="right"></td><td class="right nowrap"></td><td class="right nowrap"></td><td class="right nowrap">$20.00</td></tr>`
+	activityRowAdjustment = `<tr><td>8</td><td class="date-time">Thu<br/>10/07/2014<br/>09:15</td><td class="center"></td><td class="transaction-summary">Default fare adjustment</td><td>2</td><td class="right"></td><td class="right nowrap"></td><td class="right nowrap"></td><td class="right nowrap">$5.23</td></tr>`
+)
+
+func TestWeekCapUsage(t *testing.T) {
+	at := func(d, h int) time.Time { return time.Date(2015, time.October, d, h, 0, 0, 0, sydneyZone) }
+	a := &Activity{Transactions: []*Transaction{
+		{Number: 7, When: at(12, 8), Amount: -300}, // the next week
+		{Number: 6, When: at(8, 9), Amount: -700},  // Thursday; reaches the weekly cap
+		{Number: 5, When: at(7, 12), Amount: 2000}, // top up
+		{Number: 4, When: at(6, 8), Amount: -800},
+		{Number: 3, When: at(5, 17), Amount: -600}, // Monday; reaches the daily cap
+		{Number: 2, When: at(5, 8), Amount: -600},
+		{Number: 1, When: at(4, 8), Amount: -500}, // the week before
+	}}
+	wu := a.weekCapUsage(date(at(5, 0)), 1000, 2500)
+	want := &WeekCapUsage{
+		Start:        date(at(5, 0)),
+		End:          date(at(12, 0)),
+		Spent:        2700,
+		WeeklyCap:    2500,
+		CapReachedAt: at(8, 9),
+		DailyCapDays: []time.Time{date(at(5, 0))},
+	}
+	if !reflect.DeepEqual(wu, want) {
+		t.Errorf("weekCapUsage returned\n%+v\nwant\n%+v", wu, want)
+	}
+}
+
+func TestCapOptimizationHint(t *testing.T) {
+	tests := []struct {
+		daily, weekly CapProgress
+		want          CapHint
+	}{
+		{
+			CapProgress{420, 1580}, CapProgress{3020, 6320},
+			CapHint{RemainingToday: 1160, RemainingThisWeek: 3300, Message: "Trips are charged until another $11.60 is spent today."},
+		},
+		{
+			CapProgress{1580, 1580}, CapProgress{3020, 6320},
+			CapHint{FreeToday: true, RemainingThisWeek: 3300, Message: "The rest of today's trips are free."},
+		},
+		{
+			CapProgress{500, 1580}, CapProgress{6000, 6320},
+			CapHint{RemainingToday: 320, RemainingThisWeek: 320, Message: "Trips are charged until another $3.20 is spent today."},
+		},
+		{
+			CapProgress{0, 1580}, CapProgress{6320, 6320},
+			CapHint{FreeToday: true, FreeThisWeek: true, Message: "The rest of this week's trips are free."},
+		},
+	}
+	for _, tc := range tests {
+		cs := &CapStatus{Daily: tc.daily, Weekly: tc.weekly}
+		got, err := cs.CapOptimizationHint()
+		if err != nil {
+			t.Errorf("CapOptimizationHint with daily %v, weekly %v: %v", tc.daily, tc.weekly, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("CapOptimizationHint with daily %v, weekly %v = %+v, want %+v", tc.daily, tc.weekly, got, tc.want)
+		}
+	}
+
+	if _, err := (&CapStatus{}).CapOptimizationHint(); err == nil {
+		t.Errorf("CapOptimizationHint without caps succeeded, want error")
+	}
+}
+
+func TestReconciles(t *testing.T) {
+	const total = "\n<p id=\"period-total\">3 trips, $15.70 spent</p>\n"
+	a, err := parseActivity([]byte(activityPageOf(activityRow6, activityRow5, activityRow3, activityRowTopUp) + total))
+	if err != nil {
+		t.Fatalf("parseActivity: %v", err)
+	}
+	if want := (PeriodTotal{Trips: 3, Spent: 1570}); a.Total == nil || *a.Total != want {
+		t.Fatalf("parseActivity found period total %+v, want %+v", a.Total, want)
+	}
+	if ok, err := a.Reconciles(); !ok || err != nil {
+		t.Errorf("Reconciles = %v, %v; want true", ok, err)
+	}
+
+	// Missing a row.
+	a.Transactions = a.Transactions[1:]
+	if ok, err := a.Reconciles(); ok || err != nil {
+		t.Errorf("Reconciles with a missing transaction = %v, %v; want false", ok, err)
+	}
+
+	a.Total = nil
+	if _, err := a.Reconciles(); err != ErrNoPeriodTotal {
+		t.Errorf("Reconciles without a total: got err %v, want ErrNoPeriodTotal", err)
+	}
+
+	tests := []struct {
+		text string
+		want *PeriodTotal
+	}{
+		{"212 trips, $1,015.70 spent", &PeriodTotal{Trips: 212, Spent: 101570}},
+		{"No trips this period", nil},
+		{"", nil},
+	}
+	for _, tc := range tests {
+		page := activityPageOf(activityRow3) + `<p id="period-total">` + tc.text + `</p>`
+		a, err := parseActivity([]byte(page))
+		if err != nil {
+			t.Errorf("parseActivity with period total %q: %v", tc.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(a.Total, tc.want) {
+			t.Errorf("parseActivity with period total %q found %+v, want %+v", tc.text, a.Total, tc.want)
+		}
+	}
+}
+
+func TestTopUps(t *testing.T) {
+	withReceipt := `<tr><td>8</td><td class="date-time">Fri<br/>11/07/2014<br/>09:15</td><td class="center"></td><td class="transaction-summary">Top up - opal.com.au <a class="receipt" href="/registered/receipt?id=8">Tax invoice</a></td><td></td><td class="right"></td><td class="right nowrap"></td><td class="right nowrap"></td><td class="right nowrap">$40.00</td></tr>`
+	a, err := parseActivity([]byte(activityPageOf(withReceipt, activityRowTopUp, activityRow3)))
+	if err != nil {
+		t.Fatalf("parseActivity: %v", err)
+	}
+	tus := a.TopUps()
+	if len(tus) != 2 {
+		t.Fatalf("TopUps returned %d top ups, want 2", len(tus))
+	}
+	if tu := tus[0]; !tu.ReceiptAvailable || tu.Transaction.ReceiptURL != "/registered/receipt?id=8" || tu.Transaction.Details != "Top up - opal.com.au" {
+		t.Errorf("top up with receipt is %+v of %v", tu, tu.Transaction)
+	}
+	if tu := tus[1]; tu.ReceiptAvailable || tu.Transaction.Number != 7 {
+		t.Errorf("top up without receipt is %+v of %v", tu, tu.Transaction)
+	}
+}
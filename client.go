@@ -4,21 +4,58 @@ Package opal provides programmatic access to Opal card information.
 package opal
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html/charset"
 )
 
 // Client is an interface to the online Opal system.
 type Client struct {
-	hc *http.Client
+	hc   *http.Client
+	base *url.URL // the Opal site
+
+	clientID   string // name/version of the program using this package, if set
+	strictAuth bool   // whether NewClient checks for usable authentication
+	eagerLogin bool   // whether NewClient establishes a session
+
+	onReLogin func(trigger string)
+	dedup     bool          // whether AllActivity drops repeated transactions
+	pageDelay time.Duration // between pages fetched by AllActivity
+	retry     RetryPolicy
+	opTimeout time.Duration // bound on each page fetch, including any login
+	sem       chan struct{} // limits requests in flight, if set
+	clock     clock
+	bytesRead func(url string, n int)
+	hook      RequestHook
+	cookieAud func(url string, received []*http.Cookie)
+	loginVia  LoginStrategy // nil for the built-in username and password login
+	lenient   bool          // keep transactions with unparseable dates
+
+	mu         sync.Mutex // protects the following
+	inFlight   int        // requests awaiting a response
+	retryUntil time.Time  // when the latest retry backoff ends
+	fares      map[FareType]*FareTable
+
+	overviewPath string // relative to base
+	activityPath string // relative to base
 
 	as AuthStore
 	a  *Auth
@@ -30,13 +67,173 @@ type Auth struct {
 	Cookies            []*http.Cookie
 }
 
-var cookieBaseURL = &url.URL{
+var defaultBaseURL = &url.URL{
 	Scheme: "https",
 	Host:   "www.opal.com.au",
 }
 
+// Paths of pages on the Opal site, relative to its base URL.
+const (
+	DefaultOverviewPath = "/registered/index"
+	DefaultActivityPath = "/registered/opal-card-transactions/"
+)
+
+// An Option configures a Client.
+type Option func(*Client)
+
+// WithClientIdentity identifies the program using this package to the Opal site.
+// The name and version are added to the User-Agent header and sent in an X-Client header.
+func WithClientIdentity(name, version string) Option {
+	return func(c *Client) {
+		c.clientID = name
+		if version != "" {
+			c.clientID += "/" + version
+		}
+	}
+}
+
+// WithStrictAuthInit makes NewClient fail with ErrNoUsableAuth if the AuthStore
+// provides neither session cookies nor a username and password.
+// Otherwise such problems are only reported when the first request is made.
+func WithStrictAuthInit() Option {
+	return func(c *Client) { c.strictAuth = true }
+}
+
+// WithEagerLogin makes NewClient check the session and log in if necessary
+// before returning, rather than waiting until the first request.
+// Login failures are then reported by NewClient.
+func WithEagerLogin() Option {
+	return func(c *Client) { c.eagerLogin = true }
+}
+
+// ErrNoUsableAuth is returned by NewClient when WithStrictAuthInit is used
+// and there is no way to authenticate to Opal.
+var ErrNoUsableAuth = errors.New("no usable session cookies or credentials")
+
+// WithOnReLogin registers a function to be called whenever the client logs in again
+// because the site redirected a request to the login page,
+// which usually means the session cookies have expired.
+// The trigger describes what caused the login.
+func WithOnReLogin(f func(trigger string)) Option {
+	return func(c *Client) { c.onReLogin = f }
+}
+
+// WithDedup sets whether AllActivity drops transactions that are repeated
+// across adjacent pages. It is on by default.
+func WithDedup(dedup bool) Option {
+	return func(c *Client) { c.dedup = dedup }
+}
+
+// WithPageDelay makes operations that fetch multiple pages, such as AllActivity,
+// wait for d between fetching each page. It does not affect other requests.
+func WithPageDelay(d time.Duration) Option {
+	return func(c *Client) { c.pageDelay = d }
+}
+
+// WithOverviewPath sets the path of the account overview page,
+// which is used by Overview, AccountSummary and SessionValid.
+// The default is DefaultOverviewPath.
+func WithOverviewPath(path string) Option {
+	return func(c *Client) { c.overviewPath = path }
+}
+
+// WithActivityPath sets the path of the card activity page.
+// The card and page are selected by query parameters added to it.
+// The default is DefaultActivityPath.
+func WithActivityPath(path string) Option {
+	return func(c *Client) { c.activityPath = path }
+}
+
+// WithOperationDeadline bounds the total time taken to fetch each page,
+// including retries and logging in again if the session has expired.
+// Fetches that take longer fail with an error wrapping both
+// ErrOperationTimeout and context.DeadlineExceeded.
+func WithOperationDeadline(d time.Duration) Option {
+	return func(c *Client) { c.opTimeout = d }
+}
+
+// ErrOperationTimeout is returned when a fetch exceeds the bound set by WithOperationDeadline.
+var ErrOperationTimeout = errors.New("operation took too long")
+
+// WithMaxConcurrency limits the client to n requests awaiting a response at once,
+// however many goroutines are using it. Further requests wait for a free slot,
+// or until their context is done. By default there is no limit.
+func WithMaxConcurrency(n int) Option {
+	return func(c *Client) {
+		c.sem = nil
+		if n > 0 {
+			c.sem = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithBytesReadHook registers a function to be called with the size
+// of each page body the client reads, such as for bandwidth metrics.
+// The size is before any conversion to UTF-8.
+func WithBytesReadHook(f func(url string, n int)) Option {
+	return func(c *Client) { c.bytesRead = f }
+}
+
+// WithCookieAuditor registers a function to be called with the cookies set
+// by each response from the site that sets any, for debugging lost sessions.
+// Cookie values are replaced with "REDACTED" unless they are empty,
+// which usually means the site is clearing the cookie.
+func WithCookieAuditor(f func(url string, received []*http.Cookie)) Option {
+	return func(c *Client) { c.cookieAud = f }
+}
+
+// WithLenientParsing makes activity fetches keep transactions whose date
+// is in an unexpected format, rather than failing.
+// Such a transaction has a zero When and the problem recorded in its ParseErrors.
+func WithLenientParsing() Option {
+	return func(c *Client) { c.lenient = true }
+}
+
+// WithCheckRedirect replaces the client's redirect policy, which normally
+// fails on any redirect other than to the login page.
+// The client logs in again only when a request fails with the error from LoginRedirect,
+// so a policy that does not return it, as in
+//
+//	func(req *http.Request, via []*http.Request) error {
+//		if err := opal.LoginRedirect(req, via); err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// disables automatic re-login.
+func WithCheckRedirect(f func(req *http.Request, via []*http.Request) error) Option {
+	return func(c *Client) { c.hc.CheckRedirect = f }
+}
+
+// auditCookies reports the cookies set by resp to the cookie auditor, if any.
+func (c *Client) auditCookies(resp *http.Response) {
+	if c.cookieAud == nil || resp == nil {
+		return
+	}
+	cookies := resp.Cookies()
+	if len(cookies) == 0 {
+		return
+	}
+	for _, ck := range cookies {
+		if ck.Value != "" {
+			ck.Value = redacted
+		}
+		ck.Raw = ""
+	}
+	c.cookieAud(resp.Request.URL.String(), cookies)
+}
+
+// ErrSessionExpired is returned when the site still redirects to the login page
+// right after logging in successfully, which suggests that the site is not
+// accepting the session cookies it set.
+var ErrSessionExpired = errors.New("session rejected straight after logging in")
+
+// userAgent identifies this package to the Opal site.
+const userAgent = "opal-go/1.0"
+
 // NewClient constructs a new Client.
-func NewClient(as AuthStore) (*Client, error) {
+func NewClient(as AuthStore, opts ...Option) (*Client, error) {
 	a, err := as.Load()
 	if err != nil {
 		return nil, err
@@ -45,32 +242,141 @@ func NewClient(as AuthStore) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	jar.SetCookies(cookieBaseURL, a.Cookies)
 
 	c := &Client{
 		hc: &http.Client{
 			Jar: jar,
 		},
-		as: as,
-		a:  a,
+		base:         defaultBaseURL,
+		dedup:        true,
+		retry:        DefaultRetryPolicy,
+		clock:        realClock{},
+		overviewPath: DefaultOverviewPath,
+		activityPath: DefaultActivityPath,
+		as:           as,
+		a:            a,
 	}
 	c.hc.CheckRedirect = c.checkRedirect
+	for _, opt := range opts {
+		opt(c)
+	}
+	for _, u := range c.cookieURLs() {
+		jar.SetCookies(u, a.Cookies)
+	}
+	if c.strictAuth && !a.usable() {
+		return nil, ErrNoUsableAuth
+	}
+	if c.eagerLogin {
+		ctx := context.Background()
+		valid, err := c.SessionValid(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("checking session: %w", err)
+		}
+		if !valid {
+			if err := c.login(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
 	return c, nil
 }
 
+// usable reports whether a has unexpired cookies or credentials.
+func (a *Auth) usable() bool {
+	if a.Username != "" && a.Password != "" {
+		return true
+	}
+	now := time.Now()
+	for _, ck := range a.Cookies {
+		if ck.Expires.IsZero() || ck.Expires.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
 // WriteConfig writes the configuration to the client's AuthStore.
 func (c *Client) WriteConfig() error {
-	c.a.Cookies = c.hc.Jar.Cookies(cookieBaseURL)
+	c.a.Cookies = nil
+	seen := make(map[string]bool)
+	for _, u := range c.cookieURLs() {
+		for _, ck := range c.hc.Jar.Cookies(u) {
+			if !seen[ck.Name] {
+				seen[ck.Name] = true
+				c.a.Cookies = append(c.a.Cookies, ck)
+			}
+		}
+	}
 	return c.as.Save(c.a)
 }
 
+// cookieURLs returns the URLs whose cookies make up the session.
+// The site sets some cookies on the parent domain of its www host
+// rather than the host itself.
+func (c *Client) cookieURLs() []*url.URL {
+	urls := []*url.URL{c.base}
+	if h := c.base.Hostname(); strings.HasPrefix(h, "www.") {
+		apex := *c.base
+		apex.Host = strings.TrimPrefix(c.base.Host, "www.")
+		urls = append(urls, &apex)
+	}
+	return urls
+}
+
+// CookieValue returns the value of the named session cookie for the Opal site,
+// such as for passing to an embedded browser.
+// Session cookies grant full access to the account, so treat the value like a password.
+func (c *Client) CookieValue(name string) (string, bool) {
+	for _, ck := range c.hc.Jar.Cookies(c.base) {
+		if ck.Name == name {
+			return ck.Value, true
+		}
+	}
+	return "", false
+}
+
 // Overview fetches the account overview.
 func (c *Client) Overview() (*Overview, error) {
-	body, err := c.get("https://www.opal.com.au/registered/index")
+	o, _, err := c.overview(context.Background())
+	return o, err
+}
+
+// OverviewRaw is like Overview, but also returns the page it was parsed from,
+// for extracting information that Overview does not.
+// The page is returned even if it could not be parsed.
+func (c *Client) OverviewRaw() (*Overview, []byte, error) {
+	return c.overview(context.Background())
+}
+
+func (c *Client) overview(ctx context.Context) (*Overview, []byte, error) {
+	body, err := c.get(ctx, c.resolve(c.overviewPath, nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := parseAccountState(body); err != nil {
+		return nil, body, err
+	}
+	o, err := parseOverview(body)
+	if err != nil {
+		return nil, body, err
+	}
+	if o.WeeklyReward != nil {
+		asOf := o.DataAsOf
+		if asOf.IsZero() {
+			asOf = c.clock.Now()
+		}
+		o.WeeklyReward.ResetsAt = weekEnd(asOf)
+	}
+	return o, body, nil
+}
+
+// AccountSummary fetches the summary figures shown at the top of the account dashboard.
+func (c *Client) AccountSummary() (*AccountSummary, error) {
+	body, err := c.get(context.Background(), c.resolve(c.overviewPath, nil))
 	if err != nil {
 		return nil, err
 	}
-	return parseOverview(body)
+	return parseAccountSummary(body)
 }
 
 // An ActivityRequest configures the operation of Activity.
@@ -79,85 +385,549 @@ type ActivityRequest struct {
 	// Offset is how many pages into the past to fetch.
 	// Zero is the most recent activity.
 	Offset int
+	// Mode, if set, restricts the activity to transactions on that mode.
+	Mode TransportMode
 }
 
 // Activity fetches a subset of the activity data for a card.
 func (c *Client) Activity(req ActivityRequest) (*Activity, error) {
-	u := fmt.Sprintf("https://www.opal.com.au/registered/opal-card-transactions/?cardIndex=%d", req.CardIndex)
+	return c.activity(context.Background(), req)
+}
+
+// ActivityByMode fetches a page of activity for a card, holding only the
+// transactions on one mode. The site is asked to filter the activity,
+// but if it does not then the page is filtered here, and so may hold
+// fewer transactions than a full page.
+func (c *Client) ActivityByMode(cardIndex int, mode TransportMode, offset int) (*Activity, error) {
+	return c.activity(context.Background(), ActivityRequest{CardIndex: cardIndex, Offset: offset, Mode: mode})
+}
+
+// maxActivityPages limits how many pages AllActivity will fetch.
+const maxActivityPages = 100
+
+// AllActivity fetches all the activity data for a card,
+// fetching successive pages until one has no transactions
+// or, if duplicates are being dropped, no new ones.
+func (c *Client) AllActivity(ctx context.Context, cardIndex int) (*Activity, error) {
+	return c.activitySince(ctx, cardIndex, time.Time{})
+}
+
+// activitySince is like AllActivity, but stops after the first page
+// with a transaction before since, if it is not zero.
+func (c *Client) activitySince(ctx context.Context, cardIndex int, since time.Time) (*Activity, error) {
+	all := new(Activity)
+	seen := make(map[string]bool)
+	for offset := 0; offset < maxActivityPages; offset++ {
+		if offset > 0 && c.pageDelay > 0 {
+			select {
+			case <-time.After(c.pageDelay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		a, err := c.activity(ctx, ActivityRequest{CardIndex: cardIndex, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		if offset == 0 {
+			all.CardName, all.Notices = a.CardName, a.Notices
+		}
+		if len(a.Transactions) == 0 {
+			break
+		}
+		added := 0
+		for _, t := range a.Transactions {
+			if c.dedup {
+				// The site sometimes repeats the last transaction of a page
+				// at the top of the next.
+				id := t.ID()
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+			}
+			all.Transactions = append(all.Transactions, t)
+			added++
+		}
+		if added == 0 {
+			// The site may keep serving the last page for offsets past the end.
+			break
+		}
+		// Transactions whose time could not be parsed say nothing about the page's range.
+		if w := lastWhen(a); !since.IsZero() && !w.IsZero() && w.Before(since) {
+			break
+		}
+	}
+	return all, nil
+}
+
+// lastWhen returns the time of the last transaction in a with a known time,
+// or the zero time if there is none.
+func lastWhen(a *Activity) time.Time {
+	for i := len(a.Transactions) - 1; i >= 0; i-- {
+		if w := a.Transactions[i].When; !w.IsZero() {
+			return w
+		}
+	}
+	return time.Time{}
+}
+
+// CapHistory fetches the fare cap status shown on the overview,
+// and adds a summary of the previous week computed from the card's activity.
+// If the overview shows no caps, only PreviousWeek is set,
+// and is measured against the standard adult caps.
+func (c *Client) CapHistory(ctx context.Context, cardIndex int) (*CapStatus, error) {
+	o, _, err := c.overview(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cs := o.Caps
+	if cs == nil {
+		cs = new(CapStatus)
+	}
+	dailyCap, weeklyCap := cs.Daily.Cap, cs.Weekly.Cap
+	if dailyCap == 0 {
+		dailyCap = dailyFareCap
+	}
+	if weeklyCap == 0 {
+		weeklyCap = weeklyFareCap
+	}
+
+	end := weekEnd(c.clock.Now())
+	start := time.Date(end.Year(), end.Month(), end.Day()-14, 0, 0, 0, 0, sydneyZone)
+	a, err := c.activitySince(ctx, cardIndex, start)
+	if err != nil {
+		return nil, err
+	}
+	cs.PreviousWeek = a.weekCapUsage(start, dailyCap, weeklyCap)
+	return cs, nil
+}
+
+// ActivityIndex fetches the list of activity pages available for a card,
+// as offered by the site's period selector.
+// Each page's Offset may be used in an ActivityRequest.
+func (c *Client) ActivityIndex(cardIndex int) ([]ActivityPeriodRef, error) {
+	u := c.resolve(c.activityPath, url.Values{
+		"cardIndex": {strconv.Itoa(cardIndex)},
+	})
+	body, err := c.get(context.Background(), u)
+	if err != nil {
+		return nil, err
+	}
+	return parseActivityIndex(body)
+}
+
+func (c *Client) activity(ctx context.Context, req ActivityRequest) (*Activity, error) {
+	if req.CardIndex < 0 {
+		return nil, ErrInvalidCardIndex
+	}
+	query := url.Values{"cardIndex": {strconv.Itoa(req.CardIndex)}}
 	if req.Offset > 0 {
-		u += fmt.Sprintf("&pageIndex=%d", req.Offset)
+		query.Set("pageIndex", strconv.Itoa(req.Offset))
 	}
-	body, err := c.get(u)
+	if req.Mode != "" {
+		query.Set("transportMode", string(req.Mode))
+	}
+	u := c.resolve(c.activityPath, query)
+	p, err := c.getPage(ctx, u)
 	if err != nil {
 		return nil, err
 	}
-	return parseActivity(body)
+	if p.isJSON() {
+		a, err := parseActivityJSONWith(p.body, c.lenient)
+		return filterMode(a, req.Mode), err
+	}
+	// The site may show the first card's activity for an index that is out of range.
+	if i, ok := parseSelectedCard(p.body); ok && i != req.CardIndex {
+		return nil, ErrInvalidCardIndex
+	}
+	a, err := parseActivityWith(p.body, c.lenient)
+	return filterMode(a, req.Mode), err
+}
+
+// filterMode restricts a to transactions on mode, if the site has not already done so.
+func filterMode(a *Activity, mode TransportMode) *Activity {
+	if a == nil || mode == "" {
+		return a
+	}
+	for _, t := range a.Transactions {
+		if t.Mode != mode {
+			return a.Filter(func(t Transaction) bool { return t.Mode == mode })
+		}
+	}
+	return a
+}
+
+// ConcessionStatus fetches the concession entitlement status of a card.
+// It returns ErrNotConcession if the card is not a concession card.
+func (c *Client) ConcessionStatus(cardIndex int) (*ConcessionStatus, error) {
+	body, err := c.cardDetailsPage(cardIndex)
+	if err != nil {
+		return nil, err
+	}
+	return parseConcessionStatus(body)
+}
+
+// CardDetails fetches the details of a card.
+func (c *Client) CardDetails(cardIndex int) (*CardDetails, error) {
+	body, err := c.cardDetailsPage(cardIndex)
+	if err != nil {
+		return nil, err
+	}
+	return parseCardDetails(body)
+}
+
+func (c *Client) cardDetailsPage(cardIndex int) ([]byte, error) {
+	u := c.resolve("/registered/opal-card-details/", url.Values{
+		"cardIndex": {strconv.Itoa(cardIndex)},
+	})
+	return c.get(context.Background(), u)
+}
+
+// FetchAuthenticated fetches a page from the Opal site, logging in if required,
+// and returns its raw body. It is an escape hatch for pages that this package
+// does not yet parse.
+// The path is relative to the site's base URL; absolute URLs are rejected
+// so that session cookies are never sent elsewhere.
+func (c *Client) FetchAuthenticated(ctx context.Context, path string) ([]byte, error) {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("bad path %q: %v", path, err)
+	}
+	if ref.IsAbs() || ref.Host != "" {
+		return nil, fmt.Errorf("path %q is not relative to the Opal site", path)
+	}
+	return c.get(ctx, c.resolve(ref.Path, ref.Query()))
+}
+
+// resolve returns the absolute URL of a path on the Opal site, with an optional query.
+// The path is relative to the path of the base URL, and keeps any trailing slash.
+func (c *Client) resolve(path string, query url.Values) string {
+	u := *c.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(path, "/")
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+	return u.String()
 }
 
 var errRedirect = errors.New("internal error: login redirect detected")
 
 func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	if err := LoginRedirect(req, via); err != nil {
+		return err
+	}
+	return fmt.Errorf("hit redirect for %v", req.URL) // shouldn't happen
+}
+
+// LoginRedirect returns the error by which a Client recognises that a request
+// was redirected to the login page, or nil if req is not such a redirect.
+// It has the signature of http.Client.CheckRedirect, for use by policies
+// given to WithCheckRedirect.
+func LoginRedirect(req *http.Request, via []*http.Request) error {
 	if strings.HasPrefix(req.URL.Path, "/login/") {
 		return errRedirect
 	}
-	return fmt.Errorf("hit redirect for %v", req.URL) // shouldn't happen
+	return nil
 }
 
-func (c *Client) get(u string) (body []byte, err error) {
-	var resp *http.Response
-	for try := 1; try <= 2; try++ {
-		resp, err = c.hc.Get(u)
+// do sends an HTTP request to the Opal site.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	ua := userAgent
+	if c.clientID != "" {
+		ua = c.clientID + " " + ua
+		req.Header.Set("X-Client", c.clientID)
+	}
+	req.Header.Set("User-Agent", ua)
+	if c.sem != nil {
+		select {
+		case c.sem <- struct{}{}:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	c.mu.Lock()
+	c.inFlight++
+	c.mu.Unlock()
+	// The request remains in flight until its body is closed.
+	release := func() {
+		c.mu.Lock()
+		c.inFlight--
+		c.mu.Unlock()
+		if c.sem != nil {
+			<-c.sem
+		}
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		release()
+		return resp, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// send sends req, reporting it to the hook and cookie auditor.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if c.hook == nil {
+		resp, err := c.hc.Do(req)
+		c.auditCookies(resp)
+		return resp, err
+	}
+	done := c.hook.StartRequest(req)
+	start := time.Now()
+	resp, err := c.hc.Do(req)
+	c.auditCookies(resp)
+	info := RequestInfo{
+		Method:   req.Method,
+		URL:      req.URL.String(),
+		Duration: time.Since(start),
+		Err:      err,
+	}
+	if resp != nil {
+		info.StatusCode = resp.StatusCode
+	}
+	done(info)
+	return resp, err
+}
+
+// releasingBody is a response body that calls release once when it is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// A page is a response body fetched from the Opal site.
+type page struct {
+	body  []byte // transcoded to UTF-8 if the response declared another charset
+	ctype string // media type, such as "text/html"
+}
+
+func (p *page) isJSON() bool { return p.ctype == "application/json" }
+
+func (c *Client) get(ctx context.Context, u string) ([]byte, error) {
+	p, err := c.getPage(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return p.body, nil
+}
+
+// getWithRetry makes a GET request, retrying transient failures according to c.retry.
+// It also returns the number of requests made.
+func (c *Client) getWithRetry(ctx context.Context, u string) (*http.Response, int, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return nil, attempt - 1, err
+		}
+		resp, err := c.do(req)
 		if ue, ok := err.(*url.Error); ok {
 			err = ue.Err
 		}
-		if err == errRedirect {
-			if err = c.login(); err == nil {
-				continue // next try
+		var status int
+		if err == nil {
+			status = resp.StatusCode
+		}
+		if attempt >= c.retry.attempts() || !c.retry.retriable(req.Method, status, err) {
+			return resp, attempt, err
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		wait := c.retry.backoff(attempt)
+		until := time.Now().Add(wait)
+		c.mu.Lock()
+		if until.After(c.retryUntil) {
+			c.retryUntil = until
+		}
+		c.mu.Unlock()
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			c.mu.Lock()
+			if c.retryUntil.Equal(until) {
+				c.retryUntil = time.Time{} // no longer waiting
 			}
+			c.mu.Unlock()
+			return nil, attempt, ctx.Err()
+		}
+	}
+}
+
+// getPage fetches u, logging in again if the session has expired.
+// Errors are returned as an *OperationError.
+func (c *Client) getPage(ctx context.Context, u string) (*page, error) {
+	return c.fetchPage(ctx, u, true)
+}
+
+// fetchPage is getPage, but if relogin is not set then a redirect
+// to the login page fails rather than logging in.
+func (c *Client) fetchPage(ctx context.Context, u string, relogin bool) (*page, error) {
+	parent := ctx
+	if c.opTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opTimeout)
+		defer cancel()
+	}
+	op := &OperationError{}
+	if c.hook != nil {
+		var done func(OperationInfo)
+		ctx, done = c.hook.StartOperation(ctx, u)
+		defer func() {
+			done(OperationInfo{URL: u, Attempts: op.Attempts, ReLogins: op.ReLogins, Err: op.Err})
+		}()
+	}
+	fail := func(err error) (*page, error) {
+		if ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+			err = fmt.Errorf("%w: %w", ErrOperationTimeout, context.DeadlineExceeded)
+		}
+		op.Err = err
+		return nil, op
+	}
+	var resp *http.Response
+	var err error
+	for try := 1; try <= 2; try++ {
+		var n int
+		resp, n, err = c.getWithRetry(ctx, u)
+		op.Attempts += n
+		if errors.Is(err, errRedirect) && !relogin {
+			return fail(fmt.Errorf("redirected to %s", resp.Header.Get("Location")))
+		}
+		if errors.Is(err, errRedirect) {
+			if op.ReLogins > 0 {
+				// Logging in did not help, so don't keep trying.
+				return fail(ErrSessionExpired)
+			}
+			if c.onReLogin != nil {
+				c.onReLogin("redirect to " + resp.Header.Get("Location"))
+			}
+			op.ReLogins++
+			if err = c.login(ctx); err != nil {
+				return fail(err)
+			}
+			continue // next try
 		}
 		if err == nil {
 			break
 		}
 	}
 	if err != nil {
-		return nil, err
+		return fail(err)
 	}
-	body, err = ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
-	if err == nil && resp.StatusCode != 200 {
-		err = fmt.Errorf("HTTP response %s", resp.Status)
+	if c.bytesRead != nil {
+		c.bytesRead(u, len(body))
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if resp.StatusCode != 200 {
+		return fail(fmt.Errorf("HTTP response %s", resp.Status))
+	}
+	p := &page{body: body}
+	var params map[string]string
+	p.ctype, params, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if cs := params["charset"]; cs != "" {
+		if p.body, err = toUTF8(body, cs); err != nil {
+			return fail(err)
+		}
+	}
+	return p, nil
+}
+
+// toUTF8 transcodes body from the named character set to UTF-8.
+func toUTF8(body []byte, label string) ([]byte, error) {
+	enc, name := charset.Lookup(label)
+	if enc == nil {
+		return nil, fmt.Errorf("unknown charset %q", label)
 	}
-	return body, err
+	if name == "utf-8" {
+		return body, nil
+	}
+	return enc.NewDecoder().Bytes(body)
 }
 
-func (c *Client) login() error {
-	body, err := c.get("https://www.opal.com.au/login/index")
+// LoginMethods reports the ways of logging in that the Opal login page offers,
+// which indicates whether this package can log in automatically.
+// It also returns the login form's CSRF token, if it has one.
+func (c *Client) LoginMethods() ([]AuthMethod, string, error) {
+	body, err := c.get(context.Background(), c.resolve("/login/index", nil))
 	if err != nil {
-		return fmt.Errorf("GETting login form: %v", err)
+		return nil, "", err
 	}
-	token, err := parseLogin(body)
+	return parseLoginMethods(body)
+}
+
+// A LoginStrategy logs in to the Opal site, for accounts that the built-in
+// username and password login cannot handle.
+// It is given the client's HTTP client, whose cookie jar should receive
+// the session cookies, and the base URL of the site.
+type LoginStrategy interface {
+	Login(ctx context.Context, hc *http.Client, base *url.URL, a *Auth) error
+}
+
+// WithLoginStrategy makes the client log in using s instead of
+// submitting the username and password to the login form.
+func WithLoginStrategy(s LoginStrategy) Option {
+	return func(c *Client) { c.loginVia = s }
+}
+
+// ErrFederatedLoginRequired is returned when the login page only offers
+// logging in through an external identity provider, which this package cannot do.
+// Log in with a browser and put its session cookies in the AuthStore instead,
+// or provide a LoginStrategy.
+var ErrFederatedLoginRequired = errors.New("Opal login requires an external identity provider")
+
+func (c *Client) login(ctx context.Context) error {
+	if c.loginVia != nil {
+		return c.loginVia.Login(ctx, c.hc, c.base, c.a)
+	}
+	// The form is fetched without logging in again, which would recurse
+	// if the form itself redirected to the login page.
+	p, err := c.fetchPage(ctx, c.resolve("/login/index", nil), false)
+	if err != nil {
+		return fmt.Errorf("GETting login form: %w", err)
+	}
+	body := p.body
+	if methods, _, err := parseLoginMethods(body); err == nil && federatedOnly(methods) {
+		return ErrFederatedLoginRequired
+	}
+	lf, err := parseLogin(body)
 	if err != nil {
 		return err
 	}
 	form := url.Values{
-		"h_username": []string{c.a.Username},
-		"h_password": []string{c.a.Password},
-		"CSRFToken":  []string{token},
+		lf.usernameField: []string{c.a.Username},
+		lf.passwordField: []string{c.a.Password},
+		lf.tokenField:    []string{lf.token},
 	}
-	resp, err := c.hc.PostForm("https://www.opal.com.au/login/registeredUserUsernameAndPasswordLogin", form)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.resolve("/login/registeredUserUsernameAndPasswordLogin", nil), strings.NewReader(form.Encode()))
 	if err != nil {
-		return fmt.Errorf("POSTing login form: %v", err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("POSTing login form: %w", err)
 	}
-	_, err = ioutil.ReadAll(resp.Body)
+	body, err = ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
 	if err != nil {
-		return fmt.Errorf("reading login form response: %v", err)
+		return fmt.Errorf("reading login form response: %w", err)
 	}
 	// A successful response sets a cookie in c.hc.
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("login form response was %s", resp.Status)
 	}
-	return nil
+	return parseAccountState(body)
 }
 
 // An AuthStore is an interface for loading and saving authentication information.
@@ -171,15 +941,56 @@ type AuthStore interface {
 // Pass this to FileAuthStore if an alternate path isn't required.
 var DefaultAuthFile = filepath.Join(os.Getenv("HOME"), ".opal")
 
+// DefaultAuthPath returns the path of DefaultAuthFile, computed afresh
+// from the user's home directory. Unlike DefaultAuthFile, it fails
+// rather than returning a relative path if there is no home directory.
+func DefaultAuthPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".opal"), nil
+}
+
+// NewDefaultClient constructs a Client using the authentication information
+// in the file at DefaultAuthPath. If the file does not exist, the error
+// says where it is expected and wraps fs.ErrNotExist.
+func NewDefaultClient(opts ...Option) (*Client, error) {
+	path, err := DefaultAuthPath()
+	if err != nil {
+		return nil, fmt.Errorf("finding auth file: %v", err)
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("auth file %s does not exist; create it holding {\"Username\": ..., \"Password\": ...} readable only by you: %w", path, err)
+	}
+	return NewClient(FileAuthStore(path), opts...)
+}
+
 // FileAuthStore returns an AuthStore that stores authentication information in a named file.
-func FileAuthStore(filename string) AuthStore {
-	return fileAuthStore{filename}
+func FileAuthStore(filename string, opts ...FileAuthStoreOption) AuthStore {
+	f := fileAuthStore{filename: filename}
+	for _, opt := range opts {
+		opt(&f)
+	}
+	return f
+}
+
+// A FileAuthStoreOption configures an AuthStore returned by FileAuthStore.
+type FileAuthStoreOption func(*fileAuthStore)
+
+// CompressAuthFile makes the AuthStore gzip-compress the file it writes.
+// Compressed files are always detected when loading, whether or not this option is set.
+func CompressAuthFile() FileAuthStoreOption {
+	return func(f *fileAuthStore) { f.compress = true }
 }
 
 type fileAuthStore struct {
 	filename string
+	compress bool
 }
 
+var gzipMagic = []byte{0x1f, 0x8b}
+
 func (f fileAuthStore) Load() (*Auth, error) {
 	// Security check.
 	fi, err := os.Stat(f.filename)
@@ -194,6 +1005,16 @@ func (f fileAuthStore) Load() (*Auth, error) {
 	if err != nil {
 		return nil, err
 	}
+	if bytes.HasPrefix(raw, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("bad auth file %s: %v", f.filename, err)
+		}
+		raw, err = ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("bad auth file %s: %v", f.filename, err)
+		}
+	}
 	a := new(Auth)
 	if err := json.Unmarshal(raw, a); err != nil {
 		return nil, fmt.Errorf("bad auth file %s: %v", f.filename, err)
@@ -206,5 +1027,14 @@ func (f fileAuthStore) Save(a *Auth) error {
 	if err != nil {
 		return err
 	}
+	if f.compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(raw) // can't fail; writes to a bytes.Buffer
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		raw = buf.Bytes()
+	}
 	return ioutil.WriteFile(f.filename, raw, 0600)
 }
@@ -1,8 +1,22 @@
 package opal
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestEverything(t *testing.T) {
@@ -53,3 +67,1075 @@ func TestEverything(t *testing.T) {
 		}
 	}
 }
+
+// memAuthStore is an AuthStore that keeps authentication information in memory.
+type memAuthStore struct {
+	a Auth
+}
+
+func (m *memAuthStore) Load() (*Auth, error) {
+	a := m.a
+	return &a, nil
+}
+
+func (m *memAuthStore) Save(a *Auth) error {
+	m.a = *a
+	return nil
+}
+
+// newTestClient returns a Client that talks to the given fake Opal site.
+func newTestClient(t *testing.T, srv *httptest.Server, opts ...Option) *Client {
+	c, err := NewClient(testAuthStore(), append([]Option{withTestServer(t, srv)}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c
+}
+
+func testAuthStore() AuthStore {
+	return &memAuthStore{a: Auth{Username: "alice", Password: "secret"}}
+}
+
+// withTestServer makes a Client talk to a test server instead of the Opal site.
+func withTestServer(t *testing.T, srv *httptest.Server) Option {
+	base, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("bad test server URL: %v", err)
+	}
+	return func(c *Client) { c.base = base }
+}
+
+func TestFetchAuthenticated(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/registered/some-page" || r.URL.Query().Get("x") != "1" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "hello")
+	}))
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	body, err := c.FetchAuthenticated(context.Background(), "/registered/some-page?x=1")
+	if err != nil {
+		t.Fatalf("FetchAuthenticated: %v", err)
+	}
+	if string(body) != "hello" {
+		t.Errorf("FetchAuthenticated body = %q, want %q", body, "hello")
+	}
+
+	for _, path := range []string{
+		"https://example.com/registered/index",
+		"//example.com/registered/index",
+	} {
+		if _, err := c.FetchAuthenticated(context.Background(), path); err == nil {
+			t.Errorf("FetchAuthenticated(%q) succeeded, want error", path)
+		}
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/registered/old":
+			http.Redirect(w, r, "/registered/new", http.StatusFound)
+		case "/registered/new":
+			io.WriteString(w, "moved")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	if _, err := newTestClient(t, srv).FetchAuthenticated(context.Background(), "/registered/old"); err == nil {
+		t.Errorf("FetchAuthenticated with default redirect policy followed a redirect")
+	}
+
+	var followed []string
+	c := newTestClient(t, srv, WithCheckRedirect(func(req *http.Request, via []*http.Request) error {
+		if err := LoginRedirect(req, via); err != nil {
+			return err
+		}
+		followed = append(followed, req.URL.Path)
+		return nil
+	}))
+	body, err := c.FetchAuthenticated(context.Background(), "/registered/old")
+	if err != nil {
+		t.Fatalf("FetchAuthenticated: %v", err)
+	}
+	if string(body) != "moved" || len(followed) != 1 || followed[0] != "/registered/new" {
+		t.Errorf("FetchAuthenticated = %q after following %q, want %q after following /registered/new", body, followed, "moved")
+	}
+
+	req, err := http.NewRequest("GET", srv.URL+"/login/index", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := LoginRedirect(req, nil); !errors.Is(err, errRedirect) {
+		t.Errorf("LoginRedirect to login page = %v, want %v", err, errRedirect)
+	}
+}
+
+func TestClientIdentity(t *testing.T) {
+	var ua, xc string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ua, xc = r.UserAgent(), r.Header.Get("X-Client")
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		opts       []Option
+		wantUA     string
+		wantClient string
+	}{
+		{nil, "opal-go/1.0", ""},
+		{[]Option{WithClientIdentity("mytool", "2.3")}, "mytool/2.3 opal-go/1.0", "mytool/2.3"},
+	}
+	for _, tc := range tests {
+		c := newTestClient(t, srv, tc.opts...)
+		if _, err := c.FetchAuthenticated(context.Background(), "/"); err != nil {
+			t.Fatalf("FetchAuthenticated: %v", err)
+		}
+		if ua != tc.wantUA || xc != tc.wantClient {
+			t.Errorf("got User-Agent %q, X-Client %q; want %q, %q", ua, xc, tc.wantUA, tc.wantClient)
+		}
+	}
+}
+
+func TestActivityJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		io.WriteString(w, activityJSON)
+	}))
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	a, err := c.Activity(ActivityRequest{})
+	if err != nil {
+		t.Fatalf("c.Activity: %v", err)
+	}
+	if len(a.Transactions) != 2 {
+		t.Errorf("c.Activity returned %d transactions, want 2", len(a.Transactions))
+	}
+}
+
+func TestFileAuthStoreCompressed(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "opal")
+	want := &Auth{
+		Username: "alice",
+		Password: "secret",
+		Cookies:  []*http.Cookie{{Name: "session", Value: "abc"}},
+	}
+	if err := FileAuthStore(filename, CompressAuthFile()).Save(want); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw, gzipMagic) {
+		t.Errorf("saved auth file is not gzip-compressed")
+	}
+
+	// Loading detects compression without being told.
+	got, err := FileAuthStore(filename).Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load = %+v, want %+v", got, want)
+	}
+}
+
+func TestStrictAuthInit(t *testing.T) {
+	tests := []struct {
+		a      Auth
+		usable bool
+	}{
+		{Auth{}, false},
+		{Auth{Username: "alice"}, false},
+		{Auth{Username: "alice", Password: "secret"}, true},
+		{Auth{Cookies: []*http.Cookie{{Name: "session", Value: "abc"}}}, true},
+		{Auth{Cookies: []*http.Cookie{{Name: "session", Value: "abc", Expires: time.Now().Add(-time.Hour)}}}, false},
+	}
+	for _, tc := range tests {
+		_, err := NewClient(&memAuthStore{a: tc.a}, WithStrictAuthInit())
+		if tc.usable && err != nil {
+			t.Errorf("NewClient with %+v: %v", tc.a, err)
+		}
+		if !tc.usable && err != ErrNoUsableAuth {
+			t.Errorf("NewClient with %+v: got err %v, want ErrNoUsableAuth", tc.a, err)
+		}
+		// Without the option, construction always succeeds.
+		if _, err := NewClient(&memAuthStore{a: tc.a}); err != nil {
+			t.Errorf("NewClient with %+v and no options: %v", tc.a, err)
+		}
+	}
+}
+
+// fakeOpal is a fake Opal site.
+// It redirects requests for /registered/ pages to the login page until the client logs in.
+type fakeOpal struct {
+	mu        sync.Mutex
+	pages     map[string]string // bodies of /registered/ pages, by path
+	loginPage string            // body of the login form response
+	loginForm string            // body of the login page, if not loginPage
+	logoutTo  string            // where logging out redirects, if not the login page
+	logins    int
+}
+
+func newFakeOpal(pages map[string]string) (*fakeOpal, *httptest.Server) {
+	f := &fakeOpal{pages: pages}
+	return f, httptest.NewServer(f)
+}
+
+func (f *fakeOpal) loginCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.logins
+}
+
+func (f *fakeOpal) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch path := r.URL.Path; {
+	case path == "/login/index":
+		if f.loginForm != "" {
+			io.WriteString(w, f.loginForm)
+			return
+		}
+		io.WriteString(w, loginPage)
+	case path == "/login/registeredUserUsernameAndPasswordLogin":
+		if r.PostFormValue("h_username") != "alice" || r.PostFormValue("h_password") != "secret" || r.PostFormValue("CSRFToken") != "xxx-yyy-zzz" {
+			http.Error(w, "bad login", http.StatusForbidden)
+			return
+		}
+		f.logins++
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "ok", Path: "/"})
+		io.WriteString(w, f.loginPage)
+	case path == "/logout":
+		if r.FormValue("CSRFToken") != "xxx-yyy-zzz" {
+			http.Error(w, "bad logout", http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Path: "/", MaxAge: -1})
+		to := "/login/index"
+		if f.logoutTo != "" {
+			to = f.logoutTo
+		}
+		http.Redirect(w, r, to, http.StatusFound)
+	case strings.HasPrefix(path, "/registered/"):
+		if ck, err := r.Cookie("session"); err != nil || ck.Value != "ok" {
+			http.Redirect(w, r, "/login/index", http.StatusFound)
+			return
+		}
+		body, ok := f.pages[r.URL.RequestURI()]
+		if !ok {
+			body, ok = f.pages[path]
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, body)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestLoginFormRedirect(t *testing.T) {
+	var mu sync.Mutex
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		// Everything, including the login form, redirects to the login form.
+		http.Redirect(w, r, "/login/index", http.StatusFound)
+	}))
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	_, err := c.Overview()
+	if err == nil || !strings.Contains(err.Error(), "GETting login form: redirected to /login/index") {
+		t.Errorf("c.Overview = %v, want a login form redirect error", err)
+	}
+	if strings.Count(fmt.Sprint(err), "GETting login form") > 1 {
+		t.Errorf("c.Overview logged in recursively: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 2 {
+		t.Errorf("server was hit %d times, want 2 (the page and the login form)", hits)
+	}
+}
+
+func TestLoginErrorChain(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	c := newTestClient(t, srv, WithRetryPolicy(RetryPolicy{}))
+
+	err := c.login(context.Background())
+	var oe *OperationError
+	if !errors.As(err, &oe) {
+		t.Errorf("login with failing login form = %v, want an *OperationError in the chain", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.login(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("login with cancelled context = %v, want context.Canceled in the chain", err)
+	}
+}
+
+func TestOnReLogin(t *testing.T) {
+	f, srv := newFakeOpal(map[string]string{"/registered/index": overviewPage})
+	defer srv.Close()
+	var triggers []string
+	c := newTestClient(t, srv, WithOnReLogin(func(trigger string) {
+		triggers = append(triggers, trigger)
+	}))
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Overview(); err != nil {
+			t.Fatalf("c.Overview: %v", err)
+		}
+	}
+	if n := f.loginCount(); n != 1 {
+		t.Errorf("client logged in %d times, want 1", n)
+	}
+	if want := []string{"redirect to /login/index"}; !reflect.DeepEqual(triggers, want) {
+		t.Errorf("re-login triggers = %q, want %q", triggers, want)
+	}
+}
+
+func TestCookieValue(t *testing.T) {
+	_, srv := newFakeOpal(map[string]string{"/registered/index": overviewPage})
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	if v, ok := c.CookieValue("session"); ok {
+		t.Errorf("before login, CookieValue(\"session\") = %q, true; want no cookie", v)
+	}
+	if _, err := c.Overview(); err != nil {
+		t.Fatalf("c.Overview: %v", err)
+	}
+	if v, ok := c.CookieValue("session"); !ok || v != "ok" {
+		t.Errorf("after login, CookieValue(\"session\") = %q, %v; want \"ok\", true", v, ok)
+	}
+}
+
+func TestAllActivity(t *testing.T) {
+	const path = "/registered/opal-card-transactions/"
+	_, srv := newFakeOpal(map[string]string{
+		path + "?cardIndex=0":             activityPageOf(activityRow6, activityRow5),
+		path + "?cardIndex=0&pageIndex=1": activityPageOf(activityRow5, activityRow3), // repeats transaction 5
+		path + "?cardIndex=0&pageIndex=2": activityPageOf(),
+	})
+	defer srv.Close()
+
+	tests := []struct {
+		dedup bool
+		want  []int
+	}{
+		{true, []int{6, 5, 3}},
+		{false, []int{6, 5, 5, 3}},
+	}
+	for _, tc := range tests {
+		c := newTestClient(t, srv, WithDedup(tc.dedup))
+		a, err := c.AllActivity(context.Background(), 0)
+		if err != nil {
+			t.Fatalf("c.AllActivity: %v", err)
+		}
+		var got []int
+		for _, tr := range a.Transactions {
+			got = append(got, tr.Number)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("with dedup %v, c.AllActivity returned transactions %v, want %v", tc.dedup, got, tc.want)
+		}
+	}
+}
+
+func TestAllActivityRepeatedPage(t *testing.T) {
+	var mu sync.Mutex
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		// Every offset gets the same page.
+		io.WriteString(w, activityPageOf(activityRow6))
+	}))
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	a, err := c.AllActivity(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("c.AllActivity: %v", err)
+	}
+	if len(a.Transactions) != 1 {
+		t.Errorf("c.AllActivity returned %d transactions, want 1", len(a.Transactions))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 2 {
+		t.Errorf("c.AllActivity fetched %d pages, want 2", hits)
+	}
+}
+
+func TestActivitySinceBadDate(t *testing.T) {
+	const path = "/registered/opal-card-transactions/"
+	bad := strings.Replace(activityRow3, "Wed<br/>09/07/2014<br/>07:49", "Wed<br/>2014-07-09<br/>07:49", 1)
+	_, srv := newFakeOpal(map[string]string{
+		// The last row of the first page has a bad date, so says nothing about how far back the page goes.
+		path + "?cardIndex=0":             activityPageOf(activityRow6, bad),
+		path + "?cardIndex=0&pageIndex=1": activityPageOf(activityRow5),
+		path + "?cardIndex=0&pageIndex=2": activityPageOf(),
+	})
+	defer srv.Close()
+	c := newTestClient(t, srv, WithLenientParsing())
+
+	since := time.Date(2015, time.January, 1, 0, 0, 0, 0, sydneyZone)
+	a, err := c.activitySince(context.Background(), 0, since)
+	if err != nil {
+		t.Fatalf("c.activitySince: %v", err)
+	}
+	var got []int
+	for _, tr := range a.Transactions {
+		got = append(got, tr.Number)
+	}
+	if want := []int{6, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("c.activitySince returned transactions %v, want %v", got, want)
+	}
+}
+
+func TestActivityByMode(t *testing.T) {
+	const path = "/registered/opal-card-transactions/"
+	_, srv := newFakeOpal(map[string]string{
+		// The site filters card 0's activity, but ignores the filter for card 1.
+		path + "?cardIndex=0&transportMode=bus":             activityPageOf(activityRow6) + `<p id="period-total">1 trip, $3.50 spent</p>`,
+		path + "?cardIndex=1&pageIndex=2&transportMode=bus": activityPageOf(activityRow6, activityRow5, activityRow3),
+	})
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	tests := []struct {
+		cardIndex, offset int
+		wantTotal         bool
+	}{
+		{0, 0, true},
+		{1, 2, false},
+	}
+	for _, tc := range tests {
+		a, err := c.ActivityByMode(tc.cardIndex, ModeBus, tc.offset)
+		if err != nil {
+			t.Fatalf("c.ActivityByMode(%d, %q, %d): %v", tc.cardIndex, ModeBus, tc.offset, err)
+		}
+		if len(a.Transactions) != 1 || a.Transactions[0].Number != 6 {
+			t.Errorf("c.ActivityByMode(%d, %q, %d) returned transactions %v, want only transaction 6", tc.cardIndex, ModeBus, tc.offset, a.Transactions)
+		}
+		if got := a.Total != nil; got != tc.wantTotal {
+			t.Errorf("c.ActivityByMode(%d, %q, %d) has period total %v, want %v", tc.cardIndex, ModeBus, tc.offset, got, tc.wantTotal)
+		}
+	}
+}
+
+func TestAllActivityPageDelay(t *testing.T) {
+	const path = "/registered/opal-card-transactions/"
+	_, srv := newFakeOpal(map[string]string{
+		path + "?cardIndex=0":             activityPageOf(activityRow6),
+		path + "?cardIndex=0&pageIndex=1": activityPageOf(),
+	})
+	defer srv.Close()
+
+	const delay = 50 * time.Millisecond
+	c := newTestClient(t, srv, WithPageDelay(delay))
+	start := time.Now()
+	if _, err := c.AllActivity(context.Background(), 0); err != nil {
+		t.Fatalf("c.AllActivity: %v", err)
+	}
+	if d := time.Since(start); d < delay {
+		t.Errorf("c.AllActivity of two pages took %v, want at least %v", d, delay)
+	}
+
+	// The delay is abandoned if the context is cancelled.
+	c = newTestClient(t, srv, WithPageDelay(time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), delay)
+	defer cancel()
+	if _, err := c.AllActivity(ctx, 0); err != context.DeadlineExceeded {
+		t.Errorf("c.AllActivity with cancelled context: got err %v, want context.DeadlineExceeded", err)
+	}
+}
+
+// activityPageOf returns an activity page with the given transaction rows.
+func activityPageOf(rows ...string) string {
+	return `<html>
+<table id="transaction-data"><caption><span>My Opal activity: 31415926535 is pi</span></caption>
+<thead><tr><th>Transaction<br>number</th><th>Date/time</th><th class="narrow center">Mode</th><th>Details</th><th class="narrow center">Journey<br>number</th><th>Fare Applied</th><th class="right">Fare</th><th class="right amount">Discount</th><th class="right amount">Amount</th></tr></thead>
+<tbody>
+` + strings.Join(rows, "\n") + `
+</tbody></table>
+`
+}
+
+const (
+	activityRow6 = `<tr><td>6</td><td class="date-time">Tue<br>29/09/2015<br>07:47</td><td class="center"><img alt="bus" src="/images/icons/mode-bus.png"></td><td class="transaction-summary">Willoughby Rd nr Garland to York St nr Margaret St</td><td class="center">6</td><td></td><td class="right nowrap">$3.50</td><td class="right nowrap">$0.00</td><td class="right nowrap">-$3.50</td></tr>`
+	activityRow5 = `<tr><td>5</td><td class="date-time">Wed<br/>09/07/2014<br/>17:01</td><td class="center"><img alt="train" src="/images/icons/mode-train.png"/></td><td class="transaction-summary">Town Hall to No tap off</td><td></td><td class="right">Default fare</td><td class="right nowrap">$8.10</td><td class="right nowrap">$0.00</td><td class="right nowrap">-$8.10</td></tr>`
+	activityRow3 = `<tr><td>3</td><td class="date-time">Wed<br/>09/07/2014<br/>07:49</td><td class="center"><img alt="train" src="/images/icons/mode-train.png"/></td><td class="transaction-summary">Chatswood to Town Hall</td><td>1</td><td class="right"></td><td class="right nowrap">$4.10</td><td class="right nowrap">$0.00</td><td class="right nowrap">-$4.10</td></tr>`
+)
+
+func TestAccountClosed(t *testing.T) {
+	f, srv := newFakeOpal(map[string]string{"/registered/index": overviewPage})
+	defer srv.Close()
+	f.loginPage = accountClosedPage
+	c := newTestClient(t, srv)
+
+	_, err := c.Overview()
+	if !errors.Is(err, ErrAccountClosed) {
+		t.Errorf("c.Overview: got err %v, want ErrAccountClosed", err)
+	}
+	var oe *OperationError
+	if errors.As(err, &oe) && oe.ReLogins != 1 {
+		t.Errorf("c.Overview: OperationError records %d re-logins, want 1", oe.ReLogins)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		base, path string
+		query      url.Values
+		want       string
+	}{
+		{"https://www.opal.com.au", "/registered/index", nil, "https://www.opal.com.au/registered/index"},
+		{"https://www.opal.com.au/", "registered/index", nil, "https://www.opal.com.au/registered/index"},
+		{"https://proxy.example/opal/", "/registered/opal-card-transactions/", url.Values{"cardIndex": {"1"}},
+			"https://proxy.example/opal/registered/opal-card-transactions/?cardIndex=1"},
+		{"https://www.opal.com.au", "/search", url.Values{"q": {"Town Hall & Wynyard?"}, "a": {"100%"}},
+			"https://www.opal.com.au/search?a=100%25&q=Town+Hall+%26+Wynyard%3F"},
+	}
+	for _, tc := range tests {
+		c := &Client{}
+		var err error
+		if c.base, err = url.Parse(tc.base); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.resolve(tc.path, tc.query); got != tc.want {
+			t.Errorf("resolve(%q, %v) with base %q = %q, want %q", tc.path, tc.query, tc.base, got, tc.want)
+		}
+	}
+}
+
+func TestLatin1Response(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+		io.WriteString(w, "<td>Caf\xe9 de Wynyard</td>")
+	}))
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	body, err := c.FetchAuthenticated(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("FetchAuthenticated: %v", err)
+	}
+	if want := "<td>Café de Wynyard</td>"; string(body) != want {
+		t.Errorf("FetchAuthenticated body = %q, want %q", body, want)
+	}
+}
+
+func TestEagerLogin(t *testing.T) {
+	f, srv := newFakeOpal(map[string]string{"/registered/index": overviewPage})
+	defer srv.Close()
+
+	newTestClient(t, srv, WithEagerLogin())
+	if n := f.loginCount(); n != 1 {
+		t.Errorf("NewClient with WithEagerLogin logged in %d times, want 1", n)
+	}
+
+	bad := &memAuthStore{a: Auth{Username: "alice", Password: "wrong"}}
+	if _, err := NewClient(bad, withTestServer(t, srv), WithEagerLogin()); err == nil {
+		t.Errorf("NewClient with WithEagerLogin and bad password succeeded, want error")
+	}
+}
+
+func TestApexDomainCookies(t *testing.T) {
+	as := testAuthStore()
+	c, err := NewClient(as)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	apex := &url.URL{Scheme: "https", Host: "opal.com.au"}
+	c.hc.Jar.SetCookies(apex, []*http.Cookie{{Name: "apexsession", Value: "abc"}})
+	c.hc.Jar.SetCookies(c.base, []*http.Cookie{{Name: "JSESSIONID", Value: "def"}})
+	if err := c.WriteConfig(); err != nil {
+		t.Fatalf("c.WriteConfig: %v", err)
+	}
+
+	c, err = NewClient(as)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	for _, name := range []string{"apexsession", "JSESSIONID"} {
+		if _, ok := c.CookieValue(name); !ok {
+			t.Errorf("cookie %q was not restored for %v", name, c.base)
+		}
+	}
+}
+
+func TestConfiguredPaths(t *testing.T) {
+	_, srv := newFakeOpal(map[string]string{
+		"/registered/dashboard":            overviewPage,
+		"/registered/activity?cardIndex=0": activityPageOf(activityRow3),
+	})
+	defer srv.Close()
+	c := newTestClient(t, srv, WithOverviewPath("/registered/dashboard"), WithActivityPath("/registered/activity"))
+
+	if _, err := c.Overview(); err != nil {
+		t.Errorf("c.Overview: %v", err)
+	}
+	a, err := c.Activity(ActivityRequest{CardIndex: 0})
+	if err != nil {
+		t.Fatalf("c.Activity: %v", err)
+	}
+	if len(a.Transactions) != 1 {
+		t.Errorf("c.Activity returned %d transactions, want 1", len(a.Transactions))
+	}
+}
+
+func TestOperationDeadline(t *testing.T) {
+	// The session has expired, and the login page is slow to respond.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/login/index" {
+			http.Redirect(w, r, "/login/index", http.StatusFound)
+			return
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+		io.WriteString(w, loginPage)
+	}))
+	defer srv.Close()
+	c := newTestClient(t, srv, WithOperationDeadline(50*time.Millisecond))
+
+	start := time.Now()
+	_, err := c.Overview()
+	if !errors.Is(err, ErrOperationTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("c.Overview: got err %v, want ErrOperationTimeout wrapping context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("c.Overview took %v, want about 50ms", d)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		name, page, logoutTo string
+	}{
+		{"link", `<a href="/logout?CSRFToken=xxx-yyy-zzz">Log out</a>` + overviewPage, ""},
+		{"form", `<form action="/logout" method="post"><input type="hidden" name="CSRFToken" value="xxx-yyy-zzz"><button>Log out</button></form>` + overviewPage, ""},
+		{"redirect home", `<a href="/logout?CSRFToken=xxx-yyy-zzz">Log out</a>` + overviewPage, "/"},
+	}
+	for _, tc := range tests {
+		f, srv := newFakeOpal(map[string]string{"/registered/index": tc.page})
+		f.logoutTo = tc.logoutTo
+		c := newTestClient(t, srv)
+		if err := c.Logout(); err != nil {
+			t.Errorf("%s: c.Logout: %v", tc.name, err)
+		}
+		if valid, err := c.SessionValid(context.Background()); err != nil || valid {
+			t.Errorf("%s: after logout, c.SessionValid = %v, %v; want false, nil", tc.name, valid, err)
+		}
+		srv.Close()
+	}
+
+	_, srv := newFakeOpal(map[string]string{"/registered/index": `<a href="/logout?CSRFToken=wrong">Log out</a>` + overviewPage})
+	defer srv.Close()
+	c := newTestClient(t, srv)
+	if err := c.Logout(); err == nil {
+		t.Errorf("c.Logout with bad token succeeded, want error")
+	}
+}
+
+func TestOverviewRaw(t *testing.T) {
+	_, srv := newFakeOpal(map[string]string{"/registered/index": overviewPage})
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	o, raw, err := c.OverviewRaw()
+	if err != nil {
+		t.Fatalf("c.OverviewRaw: %v", err)
+	}
+	if len(o.Cards) != 1 {
+		t.Errorf("c.OverviewRaw returned %d cards, want 1", len(o.Cards))
+	}
+	if string(raw) != overviewPage {
+		t.Errorf("c.OverviewRaw returned page\n%s\nwant\n%s", raw, overviewPage)
+	}
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, most int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > most {
+			most = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+	c := newTestClient(t, srv, WithMaxConcurrency(2))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.FetchAuthenticated(context.Background(), "/"); err != nil {
+				t.Errorf("FetchAuthenticated: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if most > 2 {
+		t.Errorf("server saw %d requests at once, want at most 2", most)
+	}
+
+	// A request waiting for a slot gives up when its context is done.
+	c.sem <- struct{}{}
+	c.sem <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.FetchAuthenticated(ctx, "/"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("FetchAuthenticated with all slots taken: got err %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestMaxConcurrencySlowBody(t *testing.T) {
+	finish := make(chan struct{})
+	var once sync.Once
+	release := func() { once.Do(func() { close(finish) }) }
+	var mu sync.Mutex
+	var started int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		started++
+		mu.Unlock()
+		// Send the headers and part of the body, then stall.
+		io.WriteString(w, "part")
+		w.(http.Flusher).Flush()
+		<-finish
+		io.WriteString(w, " done")
+	}))
+	defer srv.Close()
+	defer release() // before closing the server, which waits for handlers
+	c := newTestClient(t, srv, WithMaxConcurrency(1))
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := c.FetchAuthenticated(context.Background(), "/")
+			done <- err
+		}()
+	}
+	waitFor(t, func() bool { mu.Lock(); defer mu.Unlock(); return started == 1 })
+	// While the first body is being read, its request is still in flight
+	// and holds the only slot.
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	n := started
+	mu.Unlock()
+	if n != 1 {
+		t.Errorf("server started %d requests while a body was being read, want 1", n)
+	}
+	if st := c.State(); st.InFlight != 1 {
+		t.Errorf("while a body was being read, client has state %+v, want 1 in flight", st)
+	}
+
+	release()
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("FetchAuthenticated: %v", err)
+		}
+	}
+	if st := c.State(); st.InFlight != 0 {
+		t.Errorf("after bodies were read, client has state %+v, want none in flight", st)
+	}
+}
+
+func TestBytesReadHook(t *testing.T) {
+	_, srv := newFakeOpal(map[string]string{"/registered/index": overviewPage})
+	defer srv.Close()
+	reads := make(map[string]int)
+	c := newTestClient(t, srv, WithBytesReadHook(func(u string, n int) {
+		reads[strings.TrimPrefix(u, srv.URL)] += n
+	}))
+
+	if _, err := c.Overview(); err != nil {
+		t.Fatalf("c.Overview: %v", err)
+	}
+	want := map[string]int{
+		"/login/index":      len(loginPage),
+		"/registered/index": len(overviewPage),
+	}
+	if !reflect.DeepEqual(reads, want) {
+		t.Errorf("bytes read hook saw %v, want %v", reads, want)
+	}
+}
+
+func TestWeeklyRewardResetsAt(t *testing.T) {
+	_, srv := newFakeOpal(map[string]string{"/registered/index": overviewRewardPage})
+	defer srv.Close()
+	c := newTestClient(t, srv)
+	fc := newFakeClock(time.Time{})
+	c.clock = fc
+
+	tests := []struct {
+		now, want time.Time
+	}{
+		{time.Date(2015, time.October, 18, 23, 30, 0, 0, sydneyZone), time.Date(2015, time.October, 19, 0, 0, 0, 0, sydneyZone)},
+		{time.Date(2015, time.October, 19, 0, 30, 0, 0, sydneyZone), time.Date(2015, time.October, 26, 0, 0, 0, 0, sydneyZone)},
+	}
+	for _, tc := range tests {
+		fc.now = tc.now
+		o, err := c.Overview()
+		if err != nil {
+			t.Fatalf("c.Overview: %v", err)
+		}
+		if got := o.WeeklyReward.ResetsAt; !got.Equal(tc.want) {
+			t.Errorf("at %v, ResetsAt = %v, want %v", tc.now, got, tc.want)
+		}
+	}
+}
+
+func TestActivityInvalidCardIndex(t *testing.T) {
+	page := `<form><select name="cardIndex"><option value="0" selected>My card</option><option value="1">Kid's card</option></select></form>` + activityPageOf(activityRow3)
+	_, srv := newFakeOpal(map[string]string{"/registered/opal-card-transactions/": page})
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	if _, err := c.Activity(ActivityRequest{CardIndex: 0}); err != nil {
+		t.Errorf("c.Activity for card 0: %v", err)
+	}
+	for _, i := range []int{5, -1} {
+		if _, err := c.Activity(ActivityRequest{CardIndex: i}); err != ErrInvalidCardIndex {
+			t.Errorf("c.Activity for card %d: got err %v, want ErrInvalidCardIndex", i, err)
+		}
+	}
+}
+
+// recordingHook is a RequestHook that records what it sees.
+type recordingHook struct {
+	mu       sync.Mutex
+	ops      []OperationInfo
+	requests []RequestInfo
+}
+
+type hookKey struct{}
+
+func (h *recordingHook) StartOperation(ctx context.Context, url string) (context.Context, func(OperationInfo)) {
+	return context.WithValue(ctx, hookKey{}, url), func(info OperationInfo) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.ops = append(h.ops, info)
+	}
+}
+
+func (h *recordingHook) StartRequest(req *http.Request) func(RequestInfo) {
+	if req.Context().Value(hookKey{}) == nil && req.Method == "GET" {
+		panic("GET request made outside an operation")
+	}
+	return func(info RequestInfo) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.requests = append(h.requests, info)
+	}
+}
+
+func TestRequestHook(t *testing.T) {
+	_, srv := newFakeOpal(map[string]string{"/registered/index": overviewPage})
+	defer srv.Close()
+	h := new(recordingHook)
+	c := newTestClient(t, srv, WithRequestHook(h))
+
+	if _, err := c.Overview(); err != nil {
+		t.Fatalf("c.Overview: %v", err)
+	}
+	// The overview is redirected to the login page, so the client logs in and tries again.
+	var reqs []string
+	for _, r := range h.requests {
+		reqs = append(reqs, fmt.Sprintf("%s %s %d", r.Method, strings.TrimPrefix(r.URL, srv.URL), r.StatusCode))
+	}
+	wantReqs := []string{
+		"GET /registered/index 302",
+		"GET /login/index 200",
+		"POST /login/registeredUserUsernameAndPasswordLogin 200",
+		"GET /registered/index 200",
+	}
+	if !reflect.DeepEqual(reqs, wantReqs) {
+		t.Errorf("hook saw requests %q, want %q", reqs, wantReqs)
+	}
+	if len(h.ops) != 2 {
+		t.Fatalf("hook saw %d operations, want 2", len(h.ops))
+	}
+	// The login page is fetched within the overview operation, so it finishes first.
+	if op := h.ops[1]; op.Attempts != 2 || op.ReLogins != 1 || op.Err != nil {
+		t.Errorf("overview operation is %+v, want 2 attempts and 1 re-login", op)
+	}
+}
+
+func TestCookieAuditor(t *testing.T) {
+	_, srv := newFakeOpal(map[string]string{"/registered/index": `<a href="/logout?CSRFToken=xxx-yyy-zzz">Log out</a>` + overviewPage})
+	defer srv.Close()
+	var audits []string
+	c := newTestClient(t, srv, WithCookieAuditor(func(u string, received []*http.Cookie) {
+		for _, ck := range received {
+			audits = append(audits, strings.TrimPrefix(u, srv.URL)+" "+ck.String())
+		}
+	}))
+
+	if _, err := c.Overview(); err != nil {
+		t.Fatalf("c.Overview: %v", err)
+	}
+	if err := c.Logout(); err != nil {
+		t.Fatalf("c.Logout: %v", err)
+	}
+	want := []string{
+		"/login/registeredUserUsernameAndPasswordLogin session=REDACTED; Path=/",
+		"/logout?CSRFToken=xxx-yyy-zzz session=; Path=/; Max-Age=0",
+	}
+	if !reflect.DeepEqual(audits, want) {
+		t.Errorf("cookie auditor saw %q, want %q", audits, want)
+	}
+}
+
+func TestNewDefaultClient(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	_, err := NewDefaultClient()
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), filepath.Join(home, ".opal")) {
+		t.Errorf("NewDefaultClient without auth file: got err %v, want one naming the missing file", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(home, ".opal"), []byte(`{"Username": "alice", "Password": "secret"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewDefaultClient(WithDedup(false))
+	if err != nil {
+		t.Fatalf("NewDefaultClient: %v", err)
+	}
+	if c.a.Username != "alice" || c.dedup {
+		t.Errorf("NewDefaultClient loaded %+v and dedup %v, want alice and options applied", c.a, c.dedup)
+	}
+}
+
+func TestSessionRejectedAfterLogin(t *testing.T) {
+	// The login succeeds, but the session cookie is never accepted.
+	var logins int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login/index":
+			io.WriteString(w, loginPage)
+		case "/login/registeredUserUsernameAndPasswordLogin":
+			logins++
+			io.WriteString(w, "welcome")
+		default:
+			http.Redirect(w, r, "/login/index", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	if _, err := c.Overview(); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("c.Overview: got err %v, want ErrSessionExpired", err)
+	}
+	if logins != 1 {
+		t.Errorf("client logged in %d times, want 1", logins)
+	}
+}
+
+func TestCapHistory(t *testing.T) {
+	row := func(n int, when string, amount string) string {
+		return fmt.Sprintf(`<tr><td>%d</td><td class="date-time">%s</td><td class="center"><img alt="bus" src="/images/icons/mode-bus.png"></td><td class="transaction-summary">Here to there</td><td></td><td></td><td class="right nowrap">%s</td><td class="right nowrap">$0.00</td><td class="right nowrap">-%s</td></tr>`, n, when, amount, amount)
+	}
+	const path = "/registered/opal-card-transactions/"
+	_, srv := newFakeOpal(map[string]string{
+		"/registered/index": overviewCapsPage,
+		path + "?cardIndex=0": activityPageOf(
+			row(5, "Tue<br>13/10/2015<br>08:00", "$4.00"), // this week
+			row(4, "Fri<br>09/10/2015<br>18:00", "$15.80"),
+			row(3, "Mon<br>05/10/2015<br>08:00", "$3.50"),
+		),
+		path + "?cardIndex=0&pageIndex=1": activityPageOf(
+			row(2, "Mon<br>05/10/2015<br>07:00", "$3.50"),
+			row(1, "Sun<br>04/10/2015<br>12:00", "$2.50"), // the week before
+		),
+	})
+	defer srv.Close()
+	c := newTestClient(t, srv)
+	c.clock = newFakeClock(time.Date(2015, time.October, 14, 9, 30, 0, 0, sydneyZone))
+
+	cs, err := c.CapHistory(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("c.CapHistory: %v", err)
+	}
+	if cs.Weekly.Spent != 3020 {
+		t.Errorf("current week spent %v, want the $30.20 shown on the overview", cs.Weekly.Spent)
+	}
+	pw := cs.PreviousWeek
+	if pw == nil {
+		t.Fatalf("PreviousWeek not set")
+	}
+	if want := time.Date(2015, time.October, 5, 0, 0, 0, 0, sydneyZone); !pw.Start.Equal(want) {
+		t.Errorf("previous week starts %v, want %v", pw.Start, want)
+	}
+	if pw.Spent != 2280 || pw.WeeklyCap != 6320 || !pw.CapReachedAt.IsZero() {
+		t.Errorf("previous week spent %v of %v, cap reached at %v; want $22.80 of $63.20, not reached", pw.Spent, pw.WeeklyCap, pw.CapReachedAt)
+	}
+	if want := []time.Time{time.Date(2015, time.October, 9, 0, 0, 0, 0, sydneyZone)}; !reflect.DeepEqual(pw.DailyCapDays, want) {
+		t.Errorf("previous week reached daily cap on %v, want %v", pw.DailyCapDays, want)
+	}
+}
+
+const federatedLoginPage = `<html>
+<h2>Log in to Opal</h2>
+<p>Opal accounts now log in with Service NSW.</p>
+<p><a class="social-login" href="/login/service-nsw">Log in with Service NSW</a></p>
+`
+
+func TestFederatedLogin(t *testing.T) {
+	f, srv := newFakeOpal(map[string]string{"/registered/index": overviewPage})
+	defer srv.Close()
+	f.loginForm = federatedLoginPage
+	c := newTestClient(t, srv)
+	if _, err := c.Overview(); !errors.Is(err, ErrFederatedLoginRequired) {
+		t.Errorf("c.Overview: got err %v, want ErrFederatedLoginRequired", err)
+	}
+
+	// A login strategy can log in instead.
+	s := loginFunc(func(ctx context.Context, hc *http.Client, base *url.URL, a *Auth) error {
+		hc.Jar.SetCookies(base, []*http.Cookie{{Name: "session", Value: "ok"}})
+		return nil
+	})
+	c = newTestClient(t, srv, WithLoginStrategy(s))
+	if _, err := c.Overview(); err != nil {
+		t.Errorf("c.Overview with login strategy: %v", err)
+	}
+}
+
+type loginFunc func(ctx context.Context, hc *http.Client, base *url.URL, a *Auth) error
+
+func (f loginFunc) Login(ctx context.Context, hc *http.Client, base *url.URL, a *Auth) error {
+	return f(ctx, hc, base, a)
+}
@@ -0,0 +1,250 @@
+package opal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// An ExportOption configures how activity is written by WriteCSV, WriteJSON and WriteTable.
+type ExportOption func(*exportConfig)
+
+// WithTimeZone sets the time zone that exported times are written in.
+// The default is Australia/Sydney, the zone the site reports times in.
+func WithTimeZone(loc *time.Location) ExportOption {
+	return func(ec *exportConfig) { ec.loc = loc }
+}
+
+// WithTimeLayout sets the layout, as understood by time.Format, of exported times.
+// The default is time.RFC3339.
+func WithTimeLayout(layout string) ExportOption {
+	return func(ec *exportConfig) { ec.layout = layout }
+}
+
+type exportConfig struct {
+	loc    *time.Location
+	layout string
+}
+
+func newExportConfig(opts []ExportOption) *exportConfig {
+	ec := &exportConfig{
+		loc:    sydneyZone,
+		layout: time.RFC3339,
+	}
+	for _, opt := range opts {
+		opt(ec)
+	}
+	return ec
+}
+
+func (ec *exportConfig) formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(ec.loc).Format(ec.layout)
+}
+
+// decimal formats m as a plain number of dollars, such as "-4.10".
+func decimal(m Money) string {
+	return strings.Replace(m.String(), "$", "", 1)
+}
+
+// exportColumns are the headings of the columns written by WriteCSV and WriteTable.
+var exportColumns = []string{"Number", "When", "Mode", "Details", "Journey number", "Fare applied", "Fare", "Discount", "Amount"}
+
+// row returns the cells of t under exportColumns.
+func (ec *exportConfig) row(t *Transaction) []string {
+	journey := ""
+	if t.JourneyNumber != 0 {
+		journey = strconv.Itoa(t.JourneyNumber)
+	}
+	return []string{
+		strconv.Itoa(t.Number),
+		ec.formatTime(t.When),
+		string(t.Mode),
+		t.Details,
+		journey,
+		t.FareApplied,
+		decimal(t.Fare),
+		decimal(t.Discount),
+		decimal(t.Amount),
+	}
+}
+
+// WriteCSV writes the activity's transactions to w as CSV,
+// with a header row followed by one row per transaction.
+func (a *Activity) WriteCSV(w io.Writer, opts ...ExportOption) error {
+	ec := newExportConfig(opts)
+	cw := csv.NewWriter(w)
+	cw.Write(exportColumns)
+	for _, t := range a.Transactions {
+		cw.Write(ec.row(t))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteTable writes the activity's transactions to w as a table
+// of aligned columns, for reading in a terminal.
+func (a *Activity) WriteTable(w io.Writer, opts ...ExportOption) error {
+	ec := newExportConfig(opts)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(exportColumns, "\t"))
+	for _, t := range a.Transactions {
+		fmt.Fprintln(tw, strings.Join(ec.row(t), "\t"))
+	}
+	return tw.Flush()
+}
+
+type jsonExportTxn struct {
+	Number        int         `json:"number"`
+	When          string      `json:"when,omitempty"`
+	Mode          string      `json:"mode,omitempty"`
+	Details       string      `json:"details"`
+	JourneyNumber int         `json:"journeyNumber,omitempty"`
+	FareApplied   string      `json:"fareApplied,omitempty"`
+	Fare          json.Number `json:"fare"`
+	Discount      json.Number `json:"discount"`
+	Amount        json.Number `json:"amount"`
+}
+
+// WriteJSON writes the activity's transactions to w as a JSON array,
+// with amounts in dollars.
+func (a *Activity) WriteJSON(w io.Writer, opts ...ExportOption) error {
+	ec := newExportConfig(opts)
+	txns := make([]jsonExportTxn, 0, len(a.Transactions))
+	for _, t := range a.Transactions {
+		txns = append(txns, jsonExportTxn{
+			Number:        t.Number,
+			When:          ec.formatTime(t.When),
+			Mode:          string(t.Mode),
+			Details:       t.Details,
+			JourneyNumber: t.JourneyNumber,
+			FareApplied:   t.FareApplied,
+			Fare:          json.Number(decimal(t.Fare)),
+			Discount:      json.Number(decimal(t.Discount)),
+			Amount:        json.Number(decimal(t.Amount)),
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(txns)
+}
+
+const (
+	ofxHeader     = `<?OFX OFXHEADER="200" VERSION="200" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>` + "\n"
+	ofxTimeLayout = "20060102150405.000[0:GMT]"
+	ofxNameLen    = 32 // maximum length of NAME
+	ofxAcctIDLen  = 22 // maximum length of ACCTID
+)
+
+type ofxDoc struct {
+	XMLName xml.Name `xml:"OFX"`
+	SignOn  struct {
+		Status   ofxStatus `xml:"SONRS>STATUS"`
+		DTServer string    `xml:"SONRS>DTSERVER"`
+		Language string    `xml:"SONRS>LANGUAGE"`
+	} `xml:"SIGNONMSGSRSV1"`
+	Statement struct {
+		TrnUID   string    `xml:"TRNUID"`
+		Status   ofxStatus `xml:"STATUS"`
+		CurDef   string    `xml:"STMTRS>CURDEF"`
+		BankID   string    `xml:"STMTRS>BANKACCTFROM>BANKID"`
+		AcctID   string    `xml:"STMTRS>BANKACCTFROM>ACCTID"`
+		AcctType string    `xml:"STMTRS>BANKACCTFROM>ACCTTYPE"`
+		Start    string    `xml:"STMTRS>BANKTRANLIST>DTSTART"`
+		End      string    `xml:"STMTRS>BANKTRANLIST>DTEND"`
+		Txns     []ofxTxn  `xml:"STMTRS>BANKTRANLIST>STMTTRN"`
+		Balance  string    `xml:"STMTRS>LEDGERBAL>BALAMT"`
+		AsOf     string    `xml:"STMTRS>LEDGERBAL>DTASOF"`
+	} `xml:"BANKMSGSRSV1>STMTTRNRS"`
+}
+
+type ofxStatus struct {
+	Code     int    `xml:"CODE"`
+	Severity string `xml:"SEVERITY"`
+}
+
+type ofxTxn struct {
+	Type   string `xml:"TRNTYPE"`
+	Posted string `xml:"DTPOSTED"`
+	Amount string `xml:"TRNAMT"`
+	FITID  string `xml:"FITID"`
+	Name   string `xml:"NAME"`
+	Memo   string `xml:"MEMO,omitempty"`
+}
+
+// WriteOFX writes the activity's transactions to w as an OFX 2 bank statement,
+// suitable for importing into personal finance software.
+// Top ups are written as credits and fares as debits.
+// The statement's ledger balance is balance, such as the card's balance
+// from Overview, as of now.
+func (a *Activity) WriteOFX(w io.Writer, balance Money) error {
+	return a.writeOFX(w, balance, time.Now())
+}
+
+func (a *Activity) writeOFX(w io.Writer, balance Money, now time.Time) error {
+	nowOFX := now.UTC().Format(ofxTimeLayout)
+	var doc ofxDoc
+	doc.SignOn.Status = ofxStatus{Code: 0, Severity: "INFO"}
+	doc.SignOn.DTServer = nowOFX
+	doc.SignOn.Language = "ENG"
+
+	st := &doc.Statement
+	st.TrnUID = "0"
+	st.Status = ofxStatus{Code: 0, Severity: "INFO"}
+	st.CurDef = "AUD"
+	st.BankID = "OPAL"
+	st.AcctID = a.CardName
+	if r := []rune(st.AcctID); len(r) > ofxAcctIDLen {
+		st.AcctID = string(r[:ofxAcctIDLen])
+	}
+	st.AcctType = "CHECKING"
+
+	var start, end time.Time
+	for _, t := range a.Transactions {
+		if start.IsZero() || t.When.Before(start) {
+			start = t.When
+		}
+		if t.When.After(end) {
+			end = t.When
+		}
+		typ := "DEBIT"
+		if t.Amount > 0 {
+			typ = "CREDIT"
+		}
+		name := t.Details
+		if r := []rune(name); len(r) > ofxNameLen {
+			name = string(r[:ofxNameLen])
+		}
+		st.Txns = append(st.Txns, ofxTxn{
+			Type:   typ,
+			Posted: t.When.UTC().Format(ofxTimeLayout),
+			Amount: decimal(t.Amount),
+			FITID:  t.ID(),
+			Name:   name,
+			Memo:   string(t.Mode),
+		})
+	}
+	st.Start = start.UTC().Format(ofxTimeLayout)
+	st.End = end.UTC().Format(ofxTimeLayout)
+	st.Balance = decimal(balance)
+	st.AsOf = nowOFX
+
+	if _, err := io.WriteString(w, xml.Header+ofxHeader); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,196 @@
+package opal
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteCSV(t *testing.T) {
+	a := &Activity{Transactions: []*Transaction{
+		{
+			Number:        3,
+			When:          time.Date(2014, time.July, 9, 7, 49, 0, 0, sydneyZone),
+			Mode:          "train",
+			Details:       "Chatswood to Town Hall",
+			JourneyNumber: 1,
+			Fare:          410,
+			Amount:        -410,
+		},
+	}}
+	const header = "Number,When,Mode,Details,Journey number,Fare applied,Fare,Discount,Amount\n"
+
+	tests := []struct {
+		opts []ExportOption
+		want string
+	}{
+		{nil, header + "3,2014-07-09T07:49:00+10:00,train,Chatswood to Town Hall,1,,4.10,0.00,-4.10\n"},
+		{
+			[]ExportOption{WithTimeZone(time.UTC), WithTimeLayout("2006-01-02 15:04")},
+			header + "3,2014-07-08 21:49,train,Chatswood to Town Hall,1,,4.10,0.00,-4.10\n",
+		},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		if err := a.WriteCSV(&buf, tc.opts...); err != nil {
+			t.Fatalf("WriteCSV: %v", err)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Errorf("WriteCSV wrote\n%s\nwant\n%s", got, tc.want)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	a := &Activity{Transactions: []*Transaction{
+		{
+			Number:        3,
+			When:          time.Date(2014, time.July, 9, 7, 49, 0, 0, sydneyZone),
+			Mode:          "train",
+			Details:       "Chatswood to Town Hall",
+			JourneyNumber: 1,
+			Fare:          410,
+			Amount:        -410,
+		},
+	}}
+	tests := []struct {
+		opts []ExportOption
+		when string
+	}{
+		{nil, "2014-07-09T07:49:00+10:00"},
+		{[]ExportOption{WithTimeZone(time.UTC), WithTimeLayout("2006-01-02 15:04")}, "2014-07-08 21:49"},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		if err := a.WriteJSON(&buf, tc.opts...); err != nil {
+			t.Fatalf("WriteJSON: %v", err)
+		}
+		var got []map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("WriteJSON wrote bad JSON: %v\n%s", err, buf.Bytes())
+		}
+		want := []map[string]interface{}{{
+			"number":        3.0,
+			"when":          tc.when,
+			"mode":          "train",
+			"details":       "Chatswood to Town Hall",
+			"journeyNumber": 1.0,
+			"fare":          4.1,
+			"discount":      0.0,
+			"amount":        -4.1,
+		}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("WriteJSON wrote %v, want %v", got, want)
+		}
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	a := &Activity{Transactions: []*Transaction{
+		{
+			Number:        3,
+			When:          time.Date(2014, time.July, 9, 7, 49, 0, 0, sydneyZone),
+			Mode:          "train",
+			Details:       "Chatswood to Town Hall",
+			JourneyNumber: 1,
+			Fare:          410,
+			Amount:        -410,
+		},
+	}}
+	var buf bytes.Buffer
+	if err := a.WriteTable(&buf, WithTimeZone(time.UTC), WithTimeLayout("2006-01-02 15:04")); err != nil {
+		t.Fatalf("WriteTable: %v", err)
+	}
+	want := "" +
+		"Number  When              Mode   Details                 Journey number  Fare applied  Fare  Discount  Amount\n" +
+		"3       2014-07-08 21:49  train  Chatswood to Town Hall  1                             4.10  0.00      -4.10\n"
+	if got := buf.String(); got != want {
+		t.Errorf("WriteTable wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteOFX(t *testing.T) {
+	a := &Activity{CardName: "James's card for the weekday commute", Transactions: []*Transaction{
+		{
+			Number:  4,
+			When:    time.Date(2014, time.July, 9, 17, 30, 0, 0, sydneyZone),
+			Details: "Top up",
+			Amount:  2000,
+		},
+		{
+			Number:        3,
+			When:          time.Date(2014, time.July, 9, 7, 49, 0, 0, sydneyZone),
+			Mode:          ModeTrain,
+			Details:       "Chatswood to Town Hall",
+			JourneyNumber: 1,
+			Fare:          410,
+			Amount:        -410,
+		},
+	}}
+	var buf bytes.Buffer
+	now := time.Date(2014, time.July, 10, 9, 0, 0, 0, sydneyZone)
+	if err := a.writeOFX(&buf, 7743, now); err != nil {
+		t.Fatalf("WriteOFX: %v", err)
+	}
+	if got := buf.String(); got != wantOFX {
+		t.Errorf("WriteOFX wrote\n%s\nwant\n%s", got, wantOFX)
+	}
+}
+
+const wantOFX = `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="200" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+  <SIGNONMSGSRSV1>
+    <SONRS>
+      <STATUS>
+        <CODE>0</CODE>
+        <SEVERITY>INFO</SEVERITY>
+      </STATUS>
+      <DTSERVER>20140709230000.000[0:GMT]</DTSERVER>
+      <LANGUAGE>ENG</LANGUAGE>
+    </SONRS>
+  </SIGNONMSGSRSV1>
+  <BANKMSGSRSV1>
+    <STMTTRNRS>
+      <TRNUID>0</TRNUID>
+      <STATUS>
+        <CODE>0</CODE>
+        <SEVERITY>INFO</SEVERITY>
+      </STATUS>
+      <STMTRS>
+        <CURDEF>AUD</CURDEF>
+        <BANKACCTFROM>
+          <BANKID>OPAL</BANKID>
+          <ACCTID>James&#39;s card for the w</ACCTID>
+          <ACCTTYPE>CHECKING</ACCTTYPE>
+        </BANKACCTFROM>
+        <BANKTRANLIST>
+          <DTSTART>20140708214900.000[0:GMT]</DTSTART>
+          <DTEND>20140709073000.000[0:GMT]</DTEND>
+          <STMTTRN>
+            <TRNTYPE>CREDIT</TRNTYPE>
+            <DTPOSTED>20140709073000.000[0:GMT]</DTPOSTED>
+            <TRNAMT>20.00</TRNAMT>
+            <FITID>4@2014-07-09T17:30:00+10:00</FITID>
+            <NAME>Top up</NAME>
+          </STMTTRN>
+          <STMTTRN>
+            <TRNTYPE>DEBIT</TRNTYPE>
+            <DTPOSTED>20140708214900.000[0:GMT]</DTPOSTED>
+            <TRNAMT>-4.10</TRNAMT>
+            <FITID>3@2014-07-09T07:49:00+10:00</FITID>
+            <NAME>Chatswood to Town Hall</NAME>
+            <MEMO>train</MEMO>
+          </STMTTRN>
+        </BANKTRANLIST>
+        <LEDGERBAL>
+          <BALAMT>77.43</BALAMT>
+          <DTASOF>20140709230000.000[0:GMT]</DTASOF>
+        </LEDGERBAL>
+      </STMTRS>
+    </STMTTRNRS>
+  </BANKMSGSRSV1>
+</OFX>
+`
@@ -0,0 +1,153 @@
+package opal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// FareType is a category of Opal card with its own fares.
+type FareType string
+
+const (
+	FareAdult      FareType = "adult"
+	FareChild      FareType = "child-youth"
+	FareConcession FareType = "concession"
+	FareSenior     FareType = "gold-senior"
+)
+
+// FareTable holds the published fares for one FareType.
+type FareTable struct {
+	Type                FareType
+	Fares               []FareBand
+	DailyCap, WeeklyCap Money // zero if not shown
+}
+
+// FareBand is the fare for travel on one mode over a range of distances.
+type FareBand struct {
+	Mode          TransportMode
+	Distance      string // as shown, e.g. "0 - 10 km"
+	Peak, OffPeak Money
+}
+
+// ErrFareTableUnavailable is returned by FetchFareTable when the fares page
+// cannot be fetched or does not have the expected structure.
+var ErrFareTableUnavailable = errors.New("fare table unavailable")
+
+// FetchFareTable fetches the published fares for a type of card.
+// The table is cached by the Client, so only the first call for each FareType
+// makes a request.
+func (c *Client) FetchFareTable(fareType FareType) (*FareTable, error) {
+	c.mu.Lock()
+	ft, ok := c.fares[fareType]
+	c.mu.Unlock()
+	if ok {
+		return ft, nil
+	}
+
+	body, err := c.get(context.Background(), c.resolve("/fares-and-payments/"+string(fareType)+"-fares", nil))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFareTableUnavailable, err)
+	}
+	ft, err = parseFareTable(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFareTableUnavailable, err)
+	}
+	ft.Type = fareType
+
+	c.mu.Lock()
+	if c.fares == nil {
+		c.fares = make(map[FareType]*FareTable)
+	}
+	c.fares[fareType] = ft
+	c.mu.Unlock()
+	return ft, nil
+}
+
+// parseFareTable parses a fares page, whose fares table has rows like
+//
+//	<tr><th>Train</th><td>0 - 10 km</td><td>$3.79</td><td>$2.65</td></tr>
+//
+// and whose caps look like
+//
+//	<dl id="fare-caps"><dt>Daily cap</dt><dd>$18.70</dd>...</dl>
+func parseFareTable(input []byte) (*FareTable, error) {
+	doc, err := html.Parse(bytes.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	table := findByAttr(doc, "id", "fares")
+	if table == nil {
+		return nil, ErrUnknownLayout
+	}
+	ft := new(FareTable)
+	eachByAtom(table, atom.Tr, func(n *html.Node) bool {
+		th := findByDataAtom(n, atom.Th)
+		if err != nil || th == nil || findByDataAtom(n, atom.Td) == nil {
+			return false
+		}
+		var tds []string
+		for kid := n.FirstChild; kid != nil; kid = kid.NextSibling {
+			if kid.DataAtom == atom.Td {
+				tds = append(tds, strings.TrimSpace(text(kid)))
+			}
+		}
+		if len(tds) != 3 {
+			err = fmt.Errorf("fare row has %d cells, want 3", len(tds))
+			return false
+		}
+		name := strings.TrimSpace(text(th))
+		mode, ok := modeNames[strings.ToLower(name)]
+		if !ok {
+			err = fmt.Errorf("unknown mode %q", name)
+			return false
+		}
+		fb := FareBand{Mode: mode, Distance: tds[0]}
+		if fb.Peak, err = parseAmount(tds[1]); err != nil {
+			err = fmt.Errorf("bad peak fare %q: %v", tds[1], err)
+			return false
+		}
+		if fb.OffPeak, err = parseAmount(tds[2]); err != nil {
+			err = fmt.Errorf("bad off-peak fare %q: %v", tds[2], err)
+			return false
+		}
+		ft.Fares = append(ft.Fares, fb)
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ft.Fares) == 0 {
+		return nil, errors.New("no fares found")
+	}
+
+	if dl := findByAttr(doc, "id", "fare-caps"); dl != nil {
+		var label string
+		for kid := dl.FirstChild; kid != nil; kid = kid.NextSibling {
+			switch kid.DataAtom {
+			case atom.Dt:
+				label = strings.ToLower(strings.TrimSpace(text(kid)))
+			case atom.Dd:
+				var dst *Money
+				switch label {
+				case "daily cap":
+					dst = &ft.DailyCap
+				case "weekly cap":
+					dst = &ft.WeeklyCap
+				default:
+					continue
+				}
+				v := strings.TrimSpace(text(kid))
+				if *dst, err = parseAmount(v); err != nil {
+					return nil, fmt.Errorf("bad %s %q: %v", label, v, err)
+				}
+			}
+		}
+	}
+	return ft, nil
+}
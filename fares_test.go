@@ -0,0 +1,68 @@
+package opal
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const faresPage = `<html><body>
+<table id="fares">
+<tr><th>Mode</th><th>Distance</th><th>Peak</th><th>Off-peak</th></tr>
+<tr><th>Train</th><td>0 - 10 km</td><td>$3.79</td><td>$2.65</td></tr>
+<tr><th>Train</th><td>10 - 20 km</td><td>$4.71</td><td>$3.29</td></tr>
+<tr><th>Bus</th><td>0 - 3 km</td><td>$3.20</td><td>$2.24</td></tr>
+</table>
+<dl id="fare-caps"><dt>Daily cap</dt><dd>$18.70</dd><dt>Weekly cap</dt><dd>$50.00</dd></dl>
+</body></html>
+`
+
+func TestFetchFareTable(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		switch r.URL.Path {
+		case "/fares-and-payments/adult-fares":
+			io.WriteString(w, faresPage)
+		case "/fares-and-payments/child-youth-fares":
+			io.WriteString(w, "<html><p>Fares are changing soon.</p></html>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	want := &FareTable{
+		Type: FareAdult,
+		Fares: []FareBand{
+			{ModeTrain, "0 - 10 km", 379, 265},
+			{ModeTrain, "10 - 20 km", 471, 329},
+			{ModeBus, "0 - 3 km", 320, 224},
+		},
+		DailyCap:  1870,
+		WeeklyCap: 5000,
+	}
+	for i := 0; i < 2; i++ {
+		ft, err := c.FetchFareTable(FareAdult)
+		if err != nil {
+			t.Fatalf("FetchFareTable: %v", err)
+		}
+		if !reflect.DeepEqual(ft, want) {
+			t.Errorf("FetchFareTable returned incorrect data.\n got %+v\nwant %+v", ft, want)
+		}
+	}
+	if hits != 1 {
+		t.Errorf("fares page was fetched %d times, want 1", hits)
+	}
+
+	if _, err := c.FetchFareTable(FareChild); !errors.Is(err, ErrFareTableUnavailable) {
+		t.Errorf("FetchFareTable of unknown layout: got err %v, want ErrFareTableUnavailable", err)
+	}
+	if _, err := c.FetchFareTable(FareSenior); !errors.Is(err, ErrFareTableUnavailable) {
+		t.Errorf("FetchFareTable of missing page: got err %v, want ErrFareTableUnavailable", err)
+	}
+}
@@ -0,0 +1,43 @@
+package opal
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// A RequestHook observes the work done by a Client, such as for tracing or metrics.
+// Its methods may be called concurrently.
+type RequestHook interface {
+	// StartOperation is called when the client starts fetching a page,
+	// which may take several requests if there are retries or it must log in again.
+	// The returned context is used for the work done for the operation,
+	// and the returned function is called with the outcome.
+	StartOperation(ctx context.Context, url string) (context.Context, func(OperationInfo))
+
+	// StartRequest is called before each HTTP request is sent,
+	// and the returned function is called with the outcome.
+	// The request's context is one returned from StartOperation, if the request is part of an operation.
+	StartRequest(req *http.Request) func(RequestInfo)
+}
+
+// OperationInfo describes the outcome of fetching a page.
+type OperationInfo struct {
+	URL      string
+	Attempts int   // HTTP requests made for the page, across all retries
+	ReLogins int   // times the client logged in again part way through
+	Err      error // nil if the page was fetched
+}
+
+// RequestInfo describes the outcome of a single HTTP request.
+type RequestInfo struct {
+	Method, URL string
+	StatusCode  int // zero if there was no response
+	Duration    time.Duration
+	Err         error
+}
+
+// WithRequestHook registers a hook that observes the client's operations and requests.
+func WithRequestHook(h RequestHook) Option {
+	return func(c *Client) { c.hook = h }
+}
@@ -0,0 +1,28 @@
+package opalmetrics_test
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/dsymonds/opal"
+	"github.com/dsymonds/opal/opalmetrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func ExampleNewHook() {
+	h, err := opalmetrics.NewHook(prometheus.DefaultRegisterer)
+	if err != nil {
+		log.Fatalf("Registering metrics: %v", err)
+	}
+	c, err := opal.NewDefaultClient(opal.WithRequestHook(h))
+	if err != nil {
+		log.Fatalf("Creating client: %v", err)
+	}
+	if _, err := c.Overview(); err != nil {
+		log.Fatalf("Fetching overview: %v", err)
+	}
+
+	http.Handle("/metrics", promhttp.Handler())
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
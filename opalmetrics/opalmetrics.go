@@ -0,0 +1,81 @@
+// Package opalmetrics records Prometheus metrics for the work done by an opal.Client.
+//
+// It is a separate package so that programs not using Prometheus
+// do not depend on it.
+package opalmetrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/dsymonds/opal"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewHook returns a hook that records metrics in reg; pass it to opal.WithRequestHook.
+// It records
+//
+//	opal_requests_total            HTTP requests, by endpoint and status
+//	opal_request_duration_seconds  HTTP request latency, by endpoint
+//	opal_relogins_total            times a client logged in again part way through a fetch
+//	opal_retries_total             HTTP requests retried after a transient failure
+//
+// The endpoint is the path of the request's URL. The status is "error"
+// for requests that got no response.
+func NewHook(reg prometheus.Registerer) (opal.RequestHook, error) {
+	h := &hook{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "opal_requests_total",
+			Help: "HTTP requests made to the Opal site.",
+		}, []string{"endpoint", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "opal_request_duration_seconds",
+			Help:    "Latency of HTTP requests made to the Opal site.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"endpoint"}),
+		relogins: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "opal_relogins_total",
+			Help: "Times the client logged in again part way through fetching a page.",
+		}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "opal_retries_total",
+			Help: "HTTP requests retried after a transient failure.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{h.requests, h.duration, h.relogins, h.retries} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+type hook struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	relogins prometheus.Counter
+	retries  prometheus.Counter
+}
+
+func (h *hook) StartOperation(ctx context.Context, url string) (context.Context, func(opal.OperationInfo)) {
+	return ctx, func(info opal.OperationInfo) {
+		h.relogins.Add(float64(info.ReLogins))
+		// Each re-login restarts the fetch, so not every extra attempt is a retry.
+		if n := info.Attempts - 1 - info.ReLogins; n > 0 {
+			h.retries.Add(float64(n))
+		}
+	}
+}
+
+func (h *hook) StartRequest(req *http.Request) func(opal.RequestInfo) {
+	endpoint := req.URL.Path
+	return func(info opal.RequestInfo) {
+		status := "error"
+		if info.StatusCode != 0 {
+			status = strconv.Itoa(info.StatusCode)
+		}
+		h.requests.WithLabelValues(endpoint, status).Inc()
+		h.duration.WithLabelValues(endpoint).Observe(info.Duration.Seconds())
+	}
+}
@@ -0,0 +1,96 @@
+package opalmetrics
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dsymonds/opal"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type memAuthStore struct{ a opal.Auth }
+
+func (m *memAuthStore) Load() (*opal.Auth, error) { a := m.a; return &a, nil }
+func (m *memAuthStore) Save(a *opal.Auth) error   { m.a = *a; return nil }
+
+const overviewPage = `<html>
+<table class="dashboard-cards" id="dashboard-active-cards"><tbody><tr><td><label>My card</label></td><td>$77.43</td><td>Active</td></tr></tbody></table>
+`
+
+func TestMetrics(t *testing.T) {
+	// Replay a recorded fetch of the overview.
+	dir := t.TempDir()
+	ex, err := json.Marshal(map[string]interface{}{
+		"Method":     "GET",
+		"URL":        "https://www.opal.com.au/registered/index",
+		"StatusCode": 200,
+		"Header":     map[string][]string{"Content-Type": {"text/html"}},
+		"Body":       overviewPage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "001.json"), ex, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reg := prometheus.NewRegistry()
+	h, err := NewHook(reg)
+	if err != nil {
+		t.Fatalf("NewHook: %v", err)
+	}
+	c, err := opal.NewClient(&memAuthStore{}, opal.WithReplayer(dir), opal.WithRequestHook(h))
+	if err != nil {
+		t.Fatalf("opal.NewClient: %v", err)
+	}
+	if _, err := c.Overview(); err != nil {
+		t.Fatalf("c.Overview: %v", err)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("reg.Gather: %v", err)
+	}
+	got := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			switch {
+			case m.Counter != nil:
+				got[mf.GetName()] += m.Counter.GetValue()
+			case m.Histogram != nil:
+				got[mf.GetName()] += float64(m.Histogram.GetSampleCount())
+			}
+		}
+	}
+	want := map[string]float64{
+		"opal_requests_total":           1,
+		"opal_request_duration_seconds": 1,
+		"opal_relogins_total":           0,
+		"opal_retries_total":            0,
+	}
+	for name, v := range want {
+		if n, ok := got[name]; !ok || n != v {
+			t.Errorf("metric %s = %v (present %v), want %v", name, n, ok, v)
+		}
+	}
+
+	// The request is labelled by endpoint and status.
+	for _, mf := range mfs {
+		if mf.GetName() != "opal_requests_total" {
+			continue
+		}
+		labels := make(map[string]string)
+		for _, lp := range mf.GetMetric()[0].GetLabel() {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		if labels["endpoint"] != "/registered/index" || labels["status"] != "200" {
+			t.Errorf("opal_requests_total has labels %v, want endpoint /registered/index and status 200", labels)
+		}
+	}
+
+	if _, err := NewHook(reg); err == nil {
+		t.Errorf("second NewHook on the same registry succeeded, want error")
+	}
+}
@@ -0,0 +1,61 @@
+// Package opaltrace records OpenTelemetry spans for the work done by an opal.Client.
+//
+// It is a separate package so that programs not using OpenTelemetry
+// do not depend on it.
+package opaltrace
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dsymonds/opal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/dsymonds/opal/opaltrace"
+
+// WithTracerProvider returns an option that makes a client record spans using tp.
+// Each page fetched gets a span, with a child span for each HTTP request made for it.
+func WithTracerProvider(tp trace.TracerProvider) opal.Option {
+	return opal.WithRequestHook(&hook{tracer: tp.Tracer(instrumentationName)})
+}
+
+type hook struct {
+	tracer trace.Tracer
+}
+
+func (h *hook) StartOperation(ctx context.Context, url string) (context.Context, func(opal.OperationInfo)) {
+	ctx, span := h.tracer.Start(ctx, "opal.fetch", trace.WithAttributes(attribute.String("url.full", url)))
+	return ctx, func(info opal.OperationInfo) {
+		span.SetAttributes(
+			attribute.Int("opal.attempts", info.Attempts),
+			attribute.Int("opal.relogins", info.ReLogins),
+		)
+		if info.Err != nil {
+			span.RecordError(info.Err)
+			span.SetStatus(codes.Error, info.Err.Error())
+		}
+		span.End()
+	}
+}
+
+func (h *hook) StartRequest(req *http.Request) func(opal.RequestInfo) {
+	_, span := h.tracer.Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.String()),
+		))
+	return func(info opal.RequestInfo) {
+		if info.StatusCode != 0 {
+			span.SetAttributes(attribute.Int("http.response.status_code", info.StatusCode))
+		}
+		if info.Err != nil {
+			span.RecordError(info.Err)
+			span.SetStatus(codes.Error, info.Err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,77 @@
+package opaltrace
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dsymonds/opal"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type memAuthStore struct{ a opal.Auth }
+
+func (m *memAuthStore) Load() (*opal.Auth, error) { a := m.a; return &a, nil }
+func (m *memAuthStore) Save(a *opal.Auth) error   { m.a = *a; return nil }
+
+const overviewPage = `<html>
+<table class="dashboard-cards" id="dashboard-active-cards"><tbody><tr><td><label>My card</label></td><td>$77.43</td><td>Active</td></tr></tbody></table>
+`
+
+func TestSpans(t *testing.T) {
+	// Replay a recorded fetch of the overview.
+	dir := t.TempDir()
+	ex, err := json.Marshal(map[string]interface{}{
+		"Method":     "GET",
+		"URL":        "https://www.opal.com.au/registered/index",
+		"StatusCode": 200,
+		"Header":     map[string][]string{"Content-Type": {"text/html"}},
+		"Body":       overviewPage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "001.json"), ex, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	c, err := opal.NewClient(&memAuthStore{}, opal.WithReplayer(dir), WithTracerProvider(tp))
+	if err != nil {
+		t.Fatalf("opal.NewClient: %v", err)
+	}
+	if _, err := c.Overview(); err != nil {
+		t.Fatalf("c.Overview: %v", err)
+	}
+
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	req, op := spans[0], spans[1]
+	if op.Name() != "opal.fetch" || req.Name() != "HTTP GET" {
+		t.Errorf("recorded spans %q and %q, want HTTP GET and opal.fetch", req.Name(), op.Name())
+	}
+	if req.Parent().SpanID() != op.SpanContext().SpanID() {
+		t.Errorf("request span is not a child of the operation span")
+	}
+	attrs := make(map[string]interface{})
+	for _, kv := range append(op.Attributes(), req.Attributes()...) {
+		attrs[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	want := map[string]interface{}{
+		"url.full":                  "https://www.opal.com.au/registered/index",
+		"opal.attempts":             int64(1),
+		"opal.relogins":             int64(0),
+		"http.request.method":       "GET",
+		"http.response.status_code": int64(200),
+	}
+	for k, v := range want {
+		if attrs[k] != v {
+			t.Errorf("attribute %s = %v, want %v", k, attrs[k], v)
+		}
+	}
+}
@@ -2,8 +2,11 @@ package opal
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math/big"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
@@ -15,21 +18,193 @@ import (
 
 // Overview represents an overview of an Opal account.
 type Overview struct {
-	Cards []Card
+	Cards        []Card
+	WeeklyReward *WeeklyReward // nil if not shown
+	Caps         *CapStatus    // nil if not shown
+	DisplayName  string        // the account holder's name, if shown
+
+	// TravelCredits are credits issued to the account,
+	// such as for service disruptions, that have not yet been used.
+	TravelCredits []TravelCredit
+
+	// DataAsOf is when the site last updated the balances, which can lag
+	// behind actual taps. It is zero if not shown.
+	DataAsOf time.Time
+
+	// ParseErrors describes optional sections that could not be parsed,
+	// which are left unset rather than failing the whole overview.
+	ParseErrors []string
+}
+
+// CapStatus describes progress towards fare caps, as shown by the site.
+type CapStatus struct {
+	Daily, Weekly CapProgress
+	// ByMode holds caps that apply to a single mode of transport, such as ferries.
+	ByMode map[TransportMode]CapProgress
+
+	// PreviousWeek summarises the last complete week, which the site does not show.
+	// It is only set by CapHistory.
+	PreviousWeek *WeekCapUsage
+
+	// ParseErrors describes cap rows that were not recognised, which are skipped.
+	ParseErrors []string
 }
 
+// WeekCapUsage summarises the fares charged to a card in one Opal week,
+// as computed from its activity.
+type WeekCapUsage struct {
+	Start, End   time.Time // midnight on the Monday starting the week and the next
+	Spent        Money
+	WeeklyCap    Money       // the cap that Spent is measured against
+	CapReachedAt time.Time   // when Spent reached WeeklyCap; zero if it did not
+	DailyCapDays []time.Time // the days on which the daily cap was reached
+}
+
+// CapProgress is the amount spent towards a single fare cap.
+type CapProgress struct {
+	Spent, Cap Money
+}
+
+// Reached reports whether the cap has been reached.
+func (p CapProgress) Reached() bool { return p.Cap > 0 && p.Spent >= p.Cap }
+
 // Card represents a single Opal card.
 type Card struct {
 	Name    string // either a name or number
-	Balance int    // in cents
+	Balance Money
+	Status  string // e.g. "Active", "Blocked"
+
+	// LowBalanceWarning is whether the site is warning that the balance is low,
+	// according to its own threshold.
+	LowBalanceWarning bool
+
+	// SerialNumber and TransitLinkNumber are the numbers printed on the card
+	// and used by the ticketing system, if the site shows them.
+	// They are exactly as shown, which is usually partly masked with asterisks.
+	SerialNumber, TransitLinkNumber string
+
+	// Primary is whether the account designates this as its primary card.
+	// At most one card in an overview is primary.
+	Primary bool
+}
+
+// TotalBalance returns the sum of the balances of the cards in the overview.
+// If activeOnly is set, cards whose status is known and not "Active" are excluded.
+func (o *Overview) TotalBalance(activeOnly bool) Money {
+	var total Money
+	for _, card := range o.Cards {
+		if activeOnly && card.Status != "" && card.Status != "Active" {
+			continue
+		}
+		total += card.Balance
+	}
+	return total
+}
+
+// A TravelCredit is value credited to the account that may lapse if unused.
+type TravelCredit struct {
+	Description string
+	Amount      Money
+	Expires     time.Time // the last day it may be used, or zero if it does not expire
+}
+
+// WeeklyReward describes progress towards the weekly travel reward.
+type WeeklyReward struct {
+	Journeys int          // paid journeys made this week
+	Tiers    []RewardTier // in increasing order of Journeys
+	ResetsAt time.Time    // when the week ends and Journeys returns to zero; the zero time if unknown
+}
+
+// weekEnd returns the end of the Opal week containing t,
+// which is midnight at the start of the following Monday in Sydney.
+func weekEnd(t time.Time) time.Time {
+	d := date(t)
+	days := (8 - int(d.Weekday())) % 7
+	if days == 0 {
+		days = 7
+	}
+	y, m, dd := d.Date()
+	return time.Date(y, m, dd+days, 0, 0, 0, 0, sydneyZone)
+}
+
+// RewardTier is a threshold of the weekly travel reward.
+type RewardTier struct {
+	Journeys int // paid journeys needed to reach this tier
+	Discount int // percentage off fares for the rest of the week
+}
+
+// rewardTiers is the built-in weekly travel reward structure.
+var rewardTiers = []RewardTier{
+	{Journeys: 8, Discount: 50},
+}
+
+// NextTier returns the lowest tier not yet reached, if any.
+func (w *WeeklyReward) NextTier() (RewardTier, bool) {
+	for _, tier := range w.Tiers {
+		if w.Journeys < tier.Journeys {
+			return tier, true
+		}
+	}
+	return RewardTier{}, false
+}
+
+// Remaining returns how many more paid journeys are needed to reach the next tier.
+// It returns zero if every tier has been reached.
+func (w *WeeklyReward) Remaining() int {
+	tier, ok := w.NextTier()
+	if !ok {
+		return 0
+	}
+	return tier.Journeys - w.Journeys
+}
+
+// Discount returns the percentage discount currently earned.
+func (w *WeeklyReward) Discount() int {
+	d := 0
+	for _, tier := range w.Tiers {
+		if w.Journeys >= tier.Journeys {
+			d = tier.Discount
+		}
+	}
+	return d
 }
 
 var (
-	amountRE = regexp.MustCompile(`^(-?)\$(\d+)\.(\d\d)$`)
+	amountRE       = regexp.MustCompile(`^(-?)\$(\d+)\.(\d\d)$`)
+	rewardRE       = regexp.MustCompile(`(\d+) paid journeys?`)
+	surchargeRE    = regexp.MustCompile(`^(\S+)\s*\+\s*(\S+) surcharge$`)
+	capRE          = regexp.MustCompile(`^(\S+) of (\S+)$`)
+	tapTimesRE     = regexp.MustCompile(`^(.*\d\d:\d\d)\s*-\s*(\d\d:\d\d)$`)
+	topUpFailureRE = regexp.MustCompile(`(?i)auto top up on (\d\d/\d\d/\d{4}) failed:? ([^.]+)`)
+	updatedRE      = regexp.MustCompile(`(?i)last updated:?\s+(\d\d/\d\d/\d{4} \d\d:\d\d)`)
+	unavailableRE  = regexp.MustCompile(`(?i)(activity|travel history) is (currently|temporarily) unavailable`)
+	closedRE       = regexp.MustCompile(`(?i)account (has been|is) closed`)
+	suspendedRE    = regexp.MustCompile(`(?i)account (has been|is) suspended`)
+	invalidCardRE  = regexp.MustCompile(`(?i)(invalid|unknown) card (index|selected)|card (could not be|was not) found`)
+	registrationRE = regexp.MustCompile(`(?i)(complete|finish) your (account )?registration`)
+	periodTotalRE  = regexp.MustCompile(`(?i)(\d+) (?:trips?|journeys?),?\s+(\$[\d,.]+) spent`)
 )
 
+// Money is an amount of money in cents.
+type Money int
+
+// String formats m in dollars, such as "$4.10" or "-$4.10".
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign, m = "-", -m
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, m/100, m%100)
+}
+
+// Rat returns m as an exact number of dollars, for use with arbitrary-precision
+// or decimal arithmetic packages.
+func (m Money) Rat() *big.Rat {
+	return big.NewRat(int64(m), 100)
+}
+
 // parseAmount parses something matching amountRE and returns the number of cents.
-func parseAmount(amt string) (int, error) {
+func parseAmount(amt string) (Money, error) {
 	m := amountRE.FindStringSubmatch(amt)
 	if m == nil {
 		return 0, fmt.Errorf("does not match /%v/", amountRE)
@@ -39,13 +214,28 @@ func parseAmount(amt string) (int, error) {
 		return 0, err
 	}
 	cents, _ := strconv.ParseInt(m[3], 10, 8) // can't fail; it's exactly two digits
-	x := int(dollars)*100 + int(cents)
+	x := Money(dollars)*100 + Money(cents)
 	if m[1] == "-" {
 		x = -x
 	}
 	return x, nil
 }
 
+// parseFare parses a fare, which may be itemized like "$3.50 + $0.70 surcharge".
+func parseFare(s string) (fare, base, surcharge Money, err error) {
+	if m := surchargeRE.FindStringSubmatch(s); m != nil {
+		if base, err = parseAmount(m[1]); err != nil {
+			return 0, 0, 0, err
+		}
+		if surcharge, err = parseAmount(m[2]); err != nil {
+			return 0, 0, 0, err
+		}
+		return base + surcharge, base, surcharge, nil
+	}
+	fare, err = parseAmount(s)
+	return fare, fare, 0, err
+}
+
 // parseCard parses card info from the name and bal TDs.
 func parseCard(name, bal string) (Card, error) {
 	// Cards can be renamed to almost anything.
@@ -61,7 +251,6 @@ func parseCard(name, bal string) (Card, error) {
 
 // parseOverview parses a page fetched from https://www.opal.com.au/registered/index.
 func parseOverview(input []byte) (*Overview, error) {
-	// TODO: check input is UTF-8
 	doc, err := html.Parse(bytes.NewReader(input))
 	if err != nil {
 		return nil, err
@@ -76,7 +265,8 @@ func parseOverview(input []byte) (*Overview, error) {
 		return nil, errors.New("did not find tbody")
 	}
 
-	var cardRows [][]string // one per row, each row having two elements (number and balance)
+	var cardRows [][]string   // one per row, each row having three elements (number, balance and status)
+	var rowNodes []*html.Node // parallel to cardRows
 	eachByAtom(tbody, atom.Tr, func(n *html.Node) bool {
 		var tds []string
 		// The card name is the first TD with a <label> inside it.
@@ -96,28 +286,278 @@ func parseOverview(input []byte) (*Overview, error) {
 			}
 			return false
 		})
+		// The status is the last TD.
+		var status string
+		eachByAtom(n, atom.Td, func(n *html.Node) bool {
+			status = strings.TrimSpace(text(n))
+			return false
+		})
 		if len(tds) == 2 {
-			cardRows = append(cardRows, tds)
+			cardRows = append(cardRows, append(tds, status))
+			rowNodes = append(rowNodes, n)
 			return false
 		}
 		return false
 	})
 
 	o := new(Overview)
-	for _, row := range cardRows {
+	for i, row := range cardRows {
 		card, err := parseCard(row[0], row[1])
 		if err != nil {
 			return nil, fmt.Errorf("parsing card row: %v", err)
 		}
+		card.Status = row[2]
+		// The site marks the row of a card with a low balance
+		// with a class, a warning cell, or both.
+		n := rowNodes[i]
+		card.LowBalanceWarning = hasClass(n, "low-balance") || findByClass(n, "low-balance-warning") != nil
+		// Card numbers are shown only for some cards, like
+		//	<span class="card-number">3085 2200 **** 1234</span>
+		if n := findByClass(n, "card-number"); n != nil {
+			card.SerialNumber = strings.TrimSpace(text(n))
+		}
+		if n := findByClass(n, "transit-link-number"); n != nil {
+			card.TransitLinkNumber = strings.TrimSpace(text(n))
+		}
+		// The primary card is marked like the low balance warning.
+		// Only the first marked card is taken to be primary.
+		if hasClass(n, "primary-card") || findByClass(n, "primary-card") != nil {
+			card.Primary = true
+			for _, c := range o.Cards {
+				if c.Primary {
+					card.Primary = false
+				}
+			}
+		}
 		o.Cards = append(o.Cards, card)
 	}
+
+	// The weekly reward progress looks like
+	//	<div id="weekly-travel-reward"><p>You've made 6 paid journeys this week.</p></div>
+	if n := findByAttr(doc, "id", "weekly-travel-reward"); n != nil {
+		t := strings.Join(strings.Fields(text(n)), " ")
+		if m := rewardRE.FindStringSubmatch(t); m == nil {
+			o.ParseErrors = append(o.ParseErrors, fmt.Sprintf("bad weekly reward %q", t))
+		} else if journeys, err := parseDecimal(m[1]); err != nil {
+			o.ParseErrors = append(o.ParseErrors, fmt.Sprintf("bad weekly reward %q: %v", t, err))
+		} else {
+			o.WeeklyReward = &WeeklyReward{
+				Journeys: journeys,
+				Tiers:    append([]RewardTier(nil), rewardTiers...),
+			}
+		}
+	}
+
+	if m := updatedRE.FindStringSubmatch(text(doc)); m != nil {
+		if o.DataAsOf, err = parseSiteTime("02/01/2006 15:04", m[1]); err != nil {
+			o.ParseErrors = append(o.ParseErrors, fmt.Sprintf("bad last updated time %q: %v", m[1], err))
+		}
+	}
+
+	o.DisplayName = parseDisplayName(doc)
+
+	if n := findByAttr(doc, "id", "fare-caps"); n != nil {
+		o.Caps, err = parseCaps(n)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if n := findByAttr(doc, "id", "travel-credits"); n != nil {
+		var problems []string
+		o.TravelCredits, problems = parseTravelCredits(n)
+		o.ParseErrors = append(o.ParseErrors, problems...)
+	}
 	return o, nil
 }
 
+// parseDisplayName finds the greeting in the page header, which looks like
+//
+//	<p class="welcome">Welcome, <strong>Alice</strong></p>
+//
+// and returns the greeted name.
+func parseDisplayName(doc *html.Node) string {
+	const prefix = "Welcome,"
+	greeting := find(doc, func(n *html.Node) bool {
+		return n.Type == html.TextNode && strings.HasPrefix(strings.TrimSpace(n.Data), prefix)
+	})
+	if greeting == nil || greeting.Parent == nil {
+		return ""
+	}
+	t := strings.Join(strings.Fields(text(greeting.Parent)), " ")
+	return strings.TrimRight(strings.TrimSpace(strings.TrimPrefix(t, prefix)), "!")
+}
+
+// parseTravelCredits parses the travel credits table, whose rows look like
+//
+//	<tr><td>Service disruption credit</td><td>$4.80</td><td>Expires 31/12/2015</td></tr>
+//
+// Rows that cannot be parsed are described in the returned problems.
+// A credit with a bad amount is skipped, and one with a bad expiry has a zero Expires.
+func parseTravelCredits(table *html.Node) (tcs []TravelCredit, problems []string) {
+	eachByAtom(table, atom.Tr, func(n *html.Node) bool {
+		var tds []string
+		eachByAtom(n, atom.Td, func(n *html.Node) bool {
+			tds = append(tds, strings.TrimSpace(text(n)))
+			return false
+		})
+		if len(tds) < 2 {
+			return false
+		}
+		tc := TravelCredit{Description: tds[0]}
+		var err error
+		if tc.Amount, err = parseAmount(tds[1]); err != nil {
+			problems = append(problems, fmt.Sprintf("bad travel credit amount %q: %v", tds[1], err))
+			return false
+		}
+		if len(tds) > 2 && tds[2] != "" {
+			exp := strings.TrimSpace(strings.TrimPrefix(tds[2], "Expires"))
+			if tc.Expires, err = parseSiteTime("02/01/2006", exp); err != nil {
+				problems = append(problems, fmt.Sprintf("bad travel credit expiry %q: %v", tds[2], err))
+			}
+		}
+		tcs = append(tcs, tc)
+		return false
+	})
+	return tcs, problems
+}
+
+// parseCaps parses the fare caps table, whose rows look like
+//
+//	<tr><th>Ferry cap</th><td>$5.20 of $15.80</td></tr>
+func parseCaps(table *html.Node) (*CapStatus, error) {
+	cs := new(CapStatus)
+	var err error
+	eachByAtom(table, atom.Tr, func(n *html.Node) bool {
+		th, td := findByDataAtom(n, atom.Th), findByDataAtom(n, atom.Td)
+		if err != nil || th == nil || td == nil {
+			return false
+		}
+		label, val := strings.TrimSpace(text(th)), strings.TrimSpace(text(td))
+		l := strings.ToLower(label)
+		mode, ok := modeNames[strings.TrimSuffix(l, " cap")]
+		if !ok && l != "daily cap" && l != "weekly cap" {
+			// The site may add caps that this package doesn't know about.
+			cs.ParseErrors = append(cs.ParseErrors, fmt.Sprintf("unknown cap %q", label))
+			return false
+		}
+		var p CapProgress
+		m := capRE.FindStringSubmatch(val)
+		if m == nil {
+			err = fmt.Errorf("bad cap %q", val)
+			return false
+		}
+		if p.Spent, err = parseAmount(m[1]); err != nil {
+			err = fmt.Errorf("bad cap %q: %v", val, err)
+			return false
+		}
+		if p.Cap, err = parseAmount(m[2]); err != nil {
+			err = fmt.Errorf("bad cap %q: %v", val, err)
+			return false
+		}
+		switch l {
+		case "daily cap":
+			cs.Daily = p
+		case "weekly cap":
+			cs.Weekly = p
+		default:
+			if cs.ByMode == nil {
+				cs.ByMode = make(map[TransportMode]CapProgress)
+			}
+			cs.ByMode[mode] = p
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// AccountSummary holds the summary figures for an Opal account.
+type AccountSummary struct {
+	Cards         int
+	TripsThisWeek int
+	SpentThisWeek Money
+}
+
+// ErrUnknownLayout is returned when a page does not have the expected structure.
+var ErrUnknownLayout = errors.New("unrecognised page layout")
+
+// parseAccountSummary parses the summary list on a page fetched from
+// https://www.opal.com.au/registered/index, which looks like
+//
+//	<dl id="account-summary"><dt>Cards</dt><dd>2</dd>...</dl>
+func parseAccountSummary(input []byte) (*AccountSummary, error) {
+	doc, err := html.Parse(bytes.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	dl := findByAttr(doc, "id", "account-summary")
+	if dl == nil || dl.DataAtom != atom.Dl {
+		return nil, fmt.Errorf("%w: did not find account summary", ErrUnknownLayout)
+	}
+
+	as := new(AccountSummary)
+	var label string
+	for kid := dl.FirstChild; kid != nil && err == nil; kid = kid.NextSibling {
+		switch kid.DataAtom {
+		case atom.Dt:
+			label = strings.TrimSpace(text(kid))
+		case atom.Dd:
+			val := strings.TrimSpace(text(kid))
+			switch label {
+			case "Cards":
+				as.Cards, err = parseDecimal(val)
+			case "Trips this week":
+				as.TripsThisWeek, err = parseDecimal(val)
+			case "Spent this week":
+				as.SpentThisWeek, err = parseAmount(val)
+			}
+			if err != nil {
+				err = fmt.Errorf("%w: bad %q value %q: %v", ErrUnknownLayout, label, val, err)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return as, nil
+}
+
 // Activity represents a subset of activity for a single card.
 type Activity struct {
 	CardName     string
 	Transactions []*Transaction
+	Notices      []Notice
+	Total        *PeriodTotal // as displayed by the site, if it is
+}
+
+// PeriodTotal is the summary of an activity page displayed by the site.
+type PeriodTotal struct {
+	Trips int
+	Spent Money
+}
+
+// Notice is a message displayed by the site alongside the raw data,
+// such as "You've reached your daily cap".
+type Notice struct {
+	Level NoticeLevel
+	Text  string
+}
+
+// NoticeLevel is the severity of a Notice.
+type NoticeLevel int
+
+const (
+	NoticeInfo NoticeLevel = iota
+	NoticeWarning
+)
+
+func (l NoticeLevel) String() string {
+	if l == NoticeWarning {
+		return "warning"
+	}
+	return "info"
 }
 
 // Transaction represents a single transaction on a card.
@@ -125,29 +565,120 @@ type Activity struct {
 // Not all fields may be set.
 type Transaction struct {
 	Number        int
-	When          time.Time
-	Mode          string // "train", etc., if known
+	When          time.Time     // when the journey started, for a trip
+	TapOff        time.Time     // if shown; may be on the day after When
+	Mode          TransportMode // if known
 	Details       string
 	JourneyNumber int // if known; numbered within the week
 
 	FareApplied            string // e.g. "Off-peak", "Travel Reward"
-	Fare, Discount, Amount int    // in cents
+	Fare, Discount, Amount Money
+
+	// BaseFare and Surcharge are the parts of Fare, if the site itemizes it.
+	// Otherwise BaseFare is the whole fare.
+	BaseFare, Surcharge Money
+
+	// ParseErrors describes optional fields that could not be parsed,
+	// which are left zero rather than failing the whole activity.
+	ParseErrors []string
+
+	// ReceiptURL is the link to the transaction's tax invoice, if the site offers one.
+	// It may be relative to the site, for use with FetchAuthenticated.
+	ReceiptURL string
+}
+
+// TransportMode is a mode of transport, as named in the transaction table.
+type TransportMode string
+
+const (
+	ModeTrain     TransportMode = "train"
+	ModeBus       TransportMode = "bus"
+	ModeFerry     TransportMode = "ferry"
+	ModeLightRail TransportMode = "lightrail"
+)
+
+// modeNames maps the names of modes in prose to their TransportMode.
+var modeNames = map[string]TransportMode{
+	"train":      ModeTrain,
+	"bus":        ModeBus,
+	"ferry":      ModeFerry,
+	"light rail": ModeLightRail,
 }
 
 func (t *Transaction) String() string { return fmt.Sprintf("%+v", *t) }
 
-func parseActivity(input []byte) (*Activity, error) {
+// ID returns an identifier for the transaction that is stable across fetches.
+func (t *Transaction) ID() string {
+	return fmt.Sprintf("%d@%s", t.Number, t.When.Format(time.RFC3339))
+}
+
+// ErrInvalidCardIndex is returned when activity is requested for a card index
+// that does not refer to one of the account's cards.
+var ErrInvalidCardIndex = errors.New("no card with that index")
+
+// parseSelectedCard returns the index of the card selected in the activity page's card selector,
+// which looks like
+//
+//	<select name="cardIndex"><option value="0">My card</option><option value="1" selected>Kid's card</option></select>
+//
+// It reports false if the page has no selector or nothing is selected.
+func parseSelectedCard(input []byte) (int, bool) {
+	doc, err := html.Parse(bytes.NewReader(input))
+	if err != nil {
+		return 0, false
+	}
+	sel := find(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Select && attrVal(n, "name") == "cardIndex"
+	})
+	if sel == nil {
+		return 0, false
+	}
+	opt := find(sel, func(n *html.Node) bool {
+		if n.DataAtom != atom.Option {
+			return false
+		}
+		for _, a := range n.Attr {
+			if a.Key == "selected" {
+				return true
+			}
+		}
+		return false
+	})
+	if opt == nil {
+		return 0, false
+	}
+	i, err := strconv.Atoi(attrVal(opt, "value"))
+	return i, err == nil
+}
+
+// ErrActivityUnavailable is returned when the site reports that a card's activity
+// is temporarily unavailable, such as for a new card. It is worth retrying later.
+var ErrActivityUnavailable = errors.New("card activity is temporarily unavailable")
+
+func parseActivity(input []byte) (*Activity, error) { return parseActivityWith(input, false) }
+
+// parseActivityWith parses an activity page.
+// If lenient is set, transactions with bad dates are kept, as described by parseTransactionCells.
+func parseActivityWith(input []byte, lenient bool) (*Activity, error) {
 	// Collapse hyphenation before parsing the HTML.
 	input = bytes.Replace(input, []byte("&shy;"), nil, -1)
 
-	// TODO: check input is UTF-8
 	doc, err := html.Parse(bytes.NewReader(input))
 	if err != nil {
 		return nil, err
 	}
 
+	if invalidCardRE.MatchString(noticeText(doc)) {
+		return nil, ErrInvalidCardIndex
+	}
+
 	table := findByAttr(doc, "id", "transaction-data")
 	if table == nil || table.DataAtom != atom.Table {
+		// The site says so in a notice in place of the table.
+		// Help text elsewhere on a page may mention it too.
+		if unavailableRE.MatchString(noticeText(doc)) {
+			return nil, ErrActivityUnavailable
+		}
 		return nil, errors.New("did not find transaction table")
 	}
 
@@ -164,6 +695,11 @@ func parseActivity(input []byte) (*Activity, error) {
 		a.CardName = strings.TrimSpace(a.CardName[i+1:])
 	}
 
+	layout, err := parseActivityLayout(table)
+	if err != nil {
+		return nil, err
+	}
+
 	tbody := findByDataAtom(table, atom.Tbody)
 	if tbody == nil {
 		return nil, errors.New("did not find tbody")
@@ -174,7 +710,7 @@ func parseActivity(input []byte) (*Activity, error) {
 			return false
 		}
 		var t *Transaction
-		t, err = parseTransaction(n)
+		t, err = parseTransaction(n, layout, lenient)
 		a.Transactions = append(a.Transactions, t)
 		return false
 	})
@@ -182,10 +718,174 @@ func parseActivity(input []byte) (*Activity, error) {
 		return nil, err
 	}
 
+	a.Notices = parseNotices(doc)
+
+	// The period total looks like
+	//	<p id="period-total">3 trips, $15.70 spent</p>
+	// Anything else, such as "No trips this period", leaves Total unset.
+	if n := findByAttr(doc, "id", "period-total"); n != nil {
+		a.Total = parsePeriodTotal(strings.Join(strings.Fields(text(n)), " "))
+	}
+
 	return a, nil
 }
 
-func parseTransaction(n *html.Node) (*Transaction, error) {
+// parsePeriodTotal parses the text of a period total, returning nil if it is not recognised.
+func parsePeriodTotal(s string) *PeriodTotal {
+	m := periodTotalRE.FindStringSubmatch(s)
+	if m == nil {
+		return nil
+	}
+	trips, err := parseDecimal(m[1])
+	if err != nil {
+		return nil
+	}
+	spent, err := parseAmount(strings.Replace(m[2], ",", "", -1))
+	if err != nil {
+		return nil
+	}
+	return &PeriodTotal{Trips: trips, Spent: spent}
+}
+
+// noticeText returns the text of the site's notices, one per line.
+func noticeText(doc *html.Node) string {
+	var lines []string
+	for _, nt := range parseNotices(doc) {
+		lines = append(lines, nt.Text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseNotices finds the site's notice messages.
+// These are elements with a "notice" class, and also a "warning" class if they are warnings.
+func parseNotices(doc *html.Node) []Notice {
+	var notices []Notice
+	each(doc, func(n *html.Node) bool {
+		if !hasClass(n, "notice") {
+			return true
+		}
+		nt := Notice{Text: strings.Join(strings.Fields(text(n)), " ")}
+		if hasClass(n, "warning") {
+			nt.Level = NoticeWarning
+		}
+		if nt.Text != "" {
+			notices = append(notices, nt)
+		}
+		return false
+	})
+	return notices
+}
+
+// ActivityPeriodRef describes one page of a card's activity.
+type ActivityPeriodRef struct {
+	Offset     int    // for use in ActivityRequest
+	Label      string // as displayed by the site
+	Start, End time.Time
+}
+
+// parseActivityIndex parses the period selector of an activity page, which looks like
+//
+//	<select id="transaction-period"><option value="0">01/10/2015 - 14/10/2015</option>...</select>
+//
+// A page without a period selector has no periods.
+func parseActivityIndex(input []byte) ([]ActivityPeriodRef, error) {
+	doc, err := html.Parse(bytes.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	sel := findByAttr(doc, "id", "transaction-period")
+	if sel == nil {
+		return nil, nil
+	}
+	var refs []ActivityPeriodRef
+	eachByAtom(sel, atom.Option, func(n *html.Node) bool {
+		if err != nil {
+			return false
+		}
+		ref := ActivityPeriodRef{Label: strings.TrimSpace(text(n))}
+		if ref.Offset, err = parseDecimal(attrVal(n, "value")); err != nil {
+			err = fmt.Errorf("bad period offset %q: %v", attrVal(n, "value"), err)
+			return false
+		}
+		dates := strings.Split(ref.Label, " - ")
+		if len(dates) != 2 {
+			err = fmt.Errorf("bad period %q", ref.Label)
+			return false
+		}
+		if ref.Start, err = parseSiteTime("02/01/2006", dates[0]); err != nil {
+			err = fmt.Errorf("bad period %q: %v", ref.Label, err)
+			return false
+		}
+		if ref.End, err = parseSiteTime("02/01/2006", dates[1]); err != nil {
+			err = fmt.Errorf("bad period %q: %v", ref.Label, err)
+			return false
+		}
+		refs = append(refs, ref)
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// transactionColumns are the columns of the transaction table, in the order
+// of the cells expected by parseTransactionCells.
+var transactionColumns = []string{
+	"Transaction number", "Date/time", "Mode", "Details", "Journey number",
+	"Fare Applied", "Fare", "Discount", "Amount",
+}
+
+// activityLayouts are the known layouts of the transaction table, newest first.
+// Each is the list of column headers.
+var activityLayouts = [][]string{
+	transactionColumns,
+	// Before late 2014 there was no fare type or discount.
+	{"Transaction number", "Date/time", "Mode", "Details", "Journey number", "Fare", "Amount"},
+}
+
+// parseActivityLayout determines the layout of the transaction table from its headers.
+// A table without headers is assumed to have the current layout.
+func parseActivityLayout(table *html.Node) ([]string, error) {
+	thead := findByDataAtom(table, atom.Thead)
+	if thead == nil {
+		return activityLayouts[0], nil
+	}
+	var headers []string
+	eachByAtom(thead, atom.Th, func(n *html.Node) bool {
+		headers = append(headers, strings.Join(strings.Fields(text(n)), " "))
+		return false
+	})
+	for _, layout := range activityLayouts {
+		if equalFold(headers, layout) {
+			return layout, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown transaction table layout %q", headers)
+}
+
+func equalFold(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseTransaction(n *html.Node, layout []string, lenient bool) (*Transaction, error) {
+	// A tax invoice is linked like
+	//	<a class="receipt" href="/registered/receipt?id=7">Tax invoice</a>
+	// which is removed so it does not end up in the cell text.
+	var receipt string
+	if a := findByClass(n, "receipt"); a != nil && a.DataAtom == atom.A {
+		receipt = attrVal(a, "href")
+		a.Parent.RemoveChild(a)
+	}
+
 	// Collate all the <TD> contents.
 	var tds []string
 	for kid := n.FirstChild; kid != nil; kid = kid.NextSibling {
@@ -196,11 +896,32 @@ func parseTransaction(n *html.Node) (*Transaction, error) {
 		}
 		tds = append(tds, text(kid))
 	}
-	if len(tds) != 9 {
-		return nil, fmt.Errorf("transaction row with %d TDs, want 9", len(tds))
+	if len(tds) != len(layout) {
+		return nil, fmt.Errorf("transaction row with %d TDs, want %d", len(tds), len(layout))
+	}
+
+	// Rearrange the cells into the current layout.
+	cells := make([]string, len(transactionColumns))
+	for i, col := range transactionColumns {
+		for j, h := range layout {
+			if h == col {
+				cells[i] = tds[j]
+			}
+		}
+	}
+	t, err := parseTransactionCells(cells, lenient)
+	if err != nil {
+		return nil, err
 	}
+	t.ReceiptURL = receipt
+	return t, nil
+}
 
-	// Parse the transaction.
+// parseTransactionCells parses a transaction from the nine cells of a row
+// in the transaction table.
+// If lenient is set, a date or tap off time in an unexpected format is recorded
+// in ParseErrors and leaves When or TapOff zero, rather than failing the whole page.
+func parseTransactionCells(tds []string, lenient bool) (*Transaction, error) {
 	t := new(Transaction)
 	var err error
 
@@ -208,30 +929,51 @@ func parseTransaction(n *html.Node) (*Transaction, error) {
 	if err != nil {
 		return nil, fmt.Errorf("bad transaction number %q: %v", tds[0], err)
 	}
-	t.When, err = time.ParseInLocation("Mon 02/01/2006 15:04", tds[1], sydneyZone)
-	if err != nil {
+	// Trips may show both tap on and tap off times, like "Wed 09/07/2014 23:50 - 00:20".
+	when, tapOff := tds[1], ""
+	if m := tapTimesRE.FindStringSubmatch(when); m != nil {
+		when, tapOff = m[1], m[2]
+	}
+	t.When, err = parseSiteTime("Mon 02/01/2006 15:04", when)
+	if err != nil && !lenient {
 		return nil, fmt.Errorf("bad time %q: %v", tds[1], err)
 	}
-	t.Mode, t.Details = tds[2], strings.TrimSpace(tds[3])
+	if err != nil {
+		t.ParseErrors = append(t.ParseErrors, fmt.Sprintf("bad time %q: %v", tds[1], err))
+	} else if tapOff != "" {
+		off, err := time.Parse("15:04", tapOff)
+		switch {
+		case err != nil && !lenient:
+			return nil, fmt.Errorf("bad tap off time %q: %v", tds[1], err)
+		case err != nil:
+			t.ParseErrors = append(t.ParseErrors, fmt.Sprintf("bad tap off time %q: %v", tds[1], err))
+		default:
+			y, m, d := t.When.Date()
+			t.TapOff = time.Date(y, m, d, off.Hour(), off.Minute(), 0, 0, sydneyZone)
+			if t.TapOff.Before(t.When) {
+				// The trip crossed midnight.
+				t.TapOff = time.Date(y, m, d+1, off.Hour(), off.Minute(), 0, 0, sydneyZone)
+			}
+		}
+	}
+	t.Mode, t.Details = TransportMode(tds[2]), strings.TrimSpace(tds[3])
 	t.FareApplied = strings.TrimSpace(tds[5])
 
-	// The rest are all optional.
+	// The rest are all optional, so a bad one doesn't spoil the rest of the row.
 	fields := []struct {
-		index  int
-		dst    *int
-		parser func(string) (int, error)
-		name   string
+		index int
+		parse func(string) error
+		name  string
 	}{
-		{4, &t.JourneyNumber, parseDecimal, "journey number"},
-		{6, &t.Fare, parseAmount, "fare"},
-		{7, &t.Discount, parseAmount, "discount"},
-		{8, &t.Amount, parseAmount, "amount"},
+		{4, func(s string) (err error) { t.JourneyNumber, err = parseDecimal(s); return }, "journey number"},
+		{6, func(s string) (err error) { t.Fare, t.BaseFare, t.Surcharge, err = parseFare(s); return }, "fare"},
+		{7, func(s string) (err error) { t.Discount, err = parseAmount(s); return }, "discount"},
+		{8, func(s string) (err error) { t.Amount, err = parseAmount(s); return }, "amount"},
 	}
 	for _, f := range fields {
 		if s := tds[f.index]; s != "" {
-			*f.dst, err = f.parser(s)
-			if err != nil {
-				return nil, fmt.Errorf("bad %s %q: %v", f.name, s, err)
+			if err := f.parse(s); err != nil {
+				t.ParseErrors = append(t.ParseErrors, fmt.Sprintf("bad %s %q: %v", f.name, s, err))
 			}
 		}
 	}
@@ -239,6 +981,195 @@ func parseTransaction(n *html.Node) (*Transaction, error) {
 	return t, nil
 }
 
+// jsonActivity is the form of activity data returned by the site's AJAX endpoints.
+// Its fields hold the same text as the corresponding transaction table cells.
+type jsonActivity struct {
+	CardName     string `json:"cardName"`
+	Transactions []struct {
+		Number        json.Number `json:"number"`
+		DateTime      string      `json:"dateTime"`
+		Mode          string      `json:"mode"`
+		Details       string      `json:"details"`
+		JourneyNumber json.Number `json:"journeyNumber"`
+		FareApplied   string      `json:"fareApplied"`
+		Fare          string      `json:"fare"`
+		Discount      string      `json:"discount"`
+		Amount        string      `json:"amount"`
+	} `json:"transactions"`
+}
+
+func parseActivityJSON(input []byte) (*Activity, error) { return parseActivityJSONWith(input, false) }
+
+func parseActivityJSONWith(input []byte, lenient bool) (*Activity, error) {
+	var ja jsonActivity
+	if err := json.Unmarshal(input, &ja); err != nil {
+		return nil, fmt.Errorf("bad activity JSON: %v", err)
+	}
+	a := &Activity{CardName: ja.CardName}
+	for _, jt := range ja.Transactions {
+		t, err := parseTransactionCells([]string{
+			jt.Number.String(), jt.DateTime, jt.Mode, jt.Details, jt.JourneyNumber.String(),
+			jt.FareApplied, jt.Fare, jt.Discount, jt.Amount,
+		}, lenient)
+		if err != nil {
+			return nil, err
+		}
+		a.Transactions = append(a.Transactions, t)
+	}
+	return a, nil
+}
+
+// ConcessionStatus represents the verification state of a concession card.
+type ConcessionStatus struct {
+	Verified   bool
+	Expired    bool
+	ReverifyBy time.Time // zero if not shown
+}
+
+// ErrNotConcession is returned by ConcessionStatus for cards that are not concession cards.
+var ErrNotConcession = errors.New("not a concession card")
+
+// parseDetails parses the label/value rows of the card details table into a map.
+// Each row has a <th> label and a <td> value.
+func parseDetails(doc *html.Node) (map[string]string, error) {
+	table := findByAttr(doc, "id", "card-details")
+	if table == nil || table.DataAtom != atom.Table {
+		return nil, errors.New("did not find card details table")
+	}
+	details := make(map[string]string)
+	eachByAtom(table, atom.Tr, func(n *html.Node) bool {
+		th, td := findByDataAtom(n, atom.Th), findByDataAtom(n, atom.Td)
+		if th != nil && td != nil {
+			details[strings.TrimSpace(text(th))] = strings.TrimSpace(text(td))
+		}
+		return false
+	})
+	return details, nil
+}
+
+// CardDetails holds the details of a single Opal card.
+type CardDetails struct {
+	Name        string
+	Type        string             // e.g. "Adult", "Concession"
+	ActivatedAt time.Time          // zero if not shown
+	AutoTopUp   *AutoTopUpSettings // nil if not shown
+}
+
+// AutoTopUpSettings describes a card's automatic top up.
+type AutoTopUpSettings struct {
+	Enabled bool
+	Amount  Money // topped up each time, if enabled
+
+	// LastFailure is set if the site reports that a recent automatic
+	// top up failed, such as because the payment card was declined.
+	LastFailure *TopUpFailure
+
+	// UnavailableReason is the site's explanation of why auto top up
+	// cannot be turned on, such as there being no payment method.
+	// It is only set if auto top up is off.
+	UnavailableReason string
+}
+
+// TopUpFailure describes a failed automatic top up.
+type TopUpFailure struct {
+	When   time.Time
+	Reason string
+}
+
+func parseCardDetails(input []byte) (*CardDetails, error) {
+	doc, err := html.Parse(bytes.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	details, err := parseDetails(doc)
+	if err != nil {
+		return nil, err
+	}
+	cd := &CardDetails{
+		Name: details["Card name"],
+		Type: details["Card type"],
+	}
+	if s := details["Activated"]; s != "" {
+		cd.ActivatedAt, err = parseSiteTime("02/01/2006", s)
+		if err != nil {
+			return nil, fmt.Errorf("bad activation date %q: %v", s, err)
+		}
+	}
+	if cd.AutoTopUp, err = parseAutoTopUp(doc, details); err != nil {
+		return nil, err
+	}
+	return cd, nil
+}
+
+// parseAutoTopUp parses the auto top up settings from the card details page.
+// A failed top up is reported in a notice like
+//
+//	<div class="notice warning"><p>Your auto top up on 12/10/2015 failed: card declined.</p></div>
+//
+// and the reason auto top up is unavailable in an element like
+//
+//	<span class="auto-top-up-reason">No payment method is registered.</span>
+func parseAutoTopUp(doc *html.Node, details map[string]string) (*AutoTopUpSettings, error) {
+	st, ok := details["Auto top up"]
+	if !ok {
+		return nil, nil
+	}
+	at := &AutoTopUpSettings{Enabled: st == "On"}
+	if s := details["Auto top up amount"]; s != "" && at.Enabled {
+		var err error
+		if at.Amount, err = parseAmount(s); err != nil {
+			return nil, fmt.Errorf("bad auto top up amount %q: %v", s, err)
+		}
+	}
+	if n := findByClass(doc, "auto-top-up-reason"); n != nil && !at.Enabled {
+		at.UnavailableReason = strings.TrimSpace(text(n))
+	}
+	for _, nt := range parseNotices(doc) {
+		m := topUpFailureRE.FindStringSubmatch(nt.Text)
+		if m == nil {
+			continue
+		}
+		when, err := parseSiteTime("02/01/2006", m[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad auto top up failure date %q: %v", m[1], err)
+		}
+		at.LastFailure = &TopUpFailure{When: when, Reason: m[2]}
+		break
+	}
+	return at, nil
+}
+
+func parseConcessionStatus(input []byte) (*ConcessionStatus, error) {
+	doc, err := html.Parse(bytes.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	details, err := parseDetails(doc)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(strings.ToLower(details["Card type"]), "concession") {
+		return nil, ErrNotConcession
+	}
+	cs := new(ConcessionStatus)
+	switch st := details["Concession status"]; st {
+	case "Verified":
+		cs.Verified = true
+	case "Expired":
+		cs.Expired = true
+	case "", "Pending verification":
+	default:
+		return nil, fmt.Errorf("unknown concession status %q", st)
+	}
+	if s := details["Re-verify by"]; s != "" {
+		cs.ReverifyBy, err = parseSiteTime("02/01/2006", s)
+		if err != nil {
+			return nil, fmt.Errorf("bad re-verification date %q: %v", s, err)
+		}
+	}
+	return cs, nil
+}
+
 var sydneyZone *time.Location // every time is in Australia/Sydney
 
 func init() {
@@ -249,28 +1180,207 @@ func init() {
 	}
 }
 
+// parseSiteTime parses a time shown on the site, which is always in Sydney time.
+func parseSiteTime(layout, s string) (time.Time, error) {
+	return time.ParseInLocation(layout, strings.TrimSpace(s), sydneyZone)
+}
+
 func parseDecimal(s string) (int, error) {
 	s = strings.TrimSpace(s)
 	x, err := strconv.ParseInt(s, 10, 0)
 	return int(x), err
 }
 
-func parseLogin(input []byte) (token string, err error) {
-	// TODO: check input is UTF-8
+// Errors returned when the account can no longer be used.
+var (
+	ErrAccountClosed    = errors.New("Opal account is closed")
+	ErrAccountSuspended = errors.New("Opal account is suspended")
+)
+
+// ErrRegistrationIncomplete is returned when the account cannot be used
+// until registration is finished on the site.
+// The error returned wraps it with a description of the outstanding step, if known.
+var ErrRegistrationIncomplete = errors.New("Opal account registration is incomplete")
+
+// parseAccountState checks a page for messages saying that the account is unusable,
+// and returns the corresponding error.
+func parseAccountState(input []byte) error {
+	doc, err := html.Parse(bytes.NewReader(input))
+	if err != nil {
+		return err
+	}
+	// Only the site's notices are checked, since body copy such as an FAQ
+	// may mention closed accounts or registration.
+	nt := noticeText(doc)
+	switch {
+	case closedRE.MatchString(nt):
+		return ErrAccountClosed
+	case suspendedRE.MatchString(nt):
+		return ErrAccountSuspended
+	case registrationRE.MatchString(nt):
+		// The outstanding steps are listed like
+		//	<li class="registration-step">Verify your email address</li>
+		step := find(doc, func(n *html.Node) bool {
+			return hasClass(n, "registration-step") && !hasClass(n, "complete")
+		})
+		if step == nil {
+			return ErrRegistrationIncomplete
+		}
+		return fmt.Errorf("%w: next step is %q", ErrRegistrationIncomplete, strings.TrimSpace(text(step)))
+	}
+	return nil
+}
+
+// A loginForm describes the fields of the login form.
+type loginForm struct {
+	usernameField, passwordField, tokenField string
+	token                                    string
+}
+
+// parseLogin finds the names of the login form's fields, and its CSRF token.
+// Fields that cannot be found are assumed to have their usual names.
+func parseLogin(input []byte) (*loginForm, error) {
 	doc, err := html.Parse(bytes.NewReader(input))
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	lf := &loginForm{
+		usernameField: "h_username",
+		passwordField: "h_password",
+		tokenField:    "CSRFToken",
 	}
-	node := findByAttr(doc, "name", "CSRFToken")
-	if node == nil {
-		return "", errors.New("did not find CSRFToken <input>")
+	var token *html.Node
+	eachByAtom(doc, atom.Input, func(n *html.Node) bool {
+		name, lname := attrVal(n, "name"), strings.ToLower(attrVal(n, "name"))
+		if name == "" {
+			return false
+		}
+		switch typ := strings.ToLower(attrVal(n, "type")); {
+		case typ == "password":
+			lf.passwordField = name
+		case (typ == "text" || typ == "email" || typ == "") && (strings.Contains(lname, "user") || strings.Contains(lname, "email")):
+			lf.usernameField = name
+		case typ == "hidden" && (name == "CSRFToken" || token == nil && strings.Contains(lname, "csrf")):
+			token = n
+		}
+		return false
+	})
+	if token == nil {
+		return nil, errors.New("did not find CSRF token <input>")
 	}
-	for _, attr := range node.Attr {
+	lf.tokenField = attrVal(token, "name")
+	for _, attr := range token.Attr {
 		if attr.Key == "value" {
-			return attr.Val, nil
+			lf.token = attr.Val
+			return lf, nil
 		}
 	}
-	return "", fmt.Errorf("unexpected form of CSRFToken: %s", render(node))
+	return nil, fmt.Errorf("unexpected form of CSRF token: %s", render(token))
+}
+
+// A logoutAction is the request that logs out of the site.
+type logoutAction struct {
+	method string     // "GET" or "POST"
+	action string     // URL, possibly relative
+	form   url.Values // for POST
+}
+
+// parseLogout finds how to log out from a page of the site.
+// Some pages have a logout form, like
+//
+//	<form action="/logout" method="post"><input type="hidden" name="CSRFToken" value="..."></form>
+//
+// and others a link with the token embedded, like
+//
+//	<a href="/logout?CSRFToken=...">Log out</a>
+func parseLogout(input []byte) (*logoutAction, error) {
+	doc, err := html.Parse(bytes.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	isLogout := func(u string) bool { return strings.Contains(strings.ToLower(u), "logout") }
+	form := find(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Form && isLogout(attrVal(n, "action"))
+	})
+	if form != nil {
+		la := &logoutAction{method: "GET", action: attrVal(form, "action"), form: url.Values{}}
+		if strings.EqualFold(attrVal(form, "method"), "post") {
+			la.method = "POST"
+		}
+		eachByAtom(form, atom.Input, func(n *html.Node) bool {
+			if name := attrVal(n, "name"); name != "" {
+				la.form.Add(name, attrVal(n, "value"))
+			}
+			return false
+		})
+		return la, nil
+	}
+	link := find(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.A && isLogout(attrVal(n, "href"))
+	})
+	if link != nil {
+		return &logoutAction{method: "GET", action: attrVal(link, "href")}, nil
+	}
+	return nil, errors.New("did not find logout form or link")
+}
+
+// AuthMethod is a way of logging in that is offered by the login page.
+type AuthMethod string
+
+const (
+	AuthPassword AuthMethod = "password" // username and password
+	AuthMFA      AuthMethod = "mfa"      // a one-time code, in addition to a password
+	AuthSocial   AuthMethod = "social"   // an external identity provider
+)
+
+// federatedOnly reports whether methods only allow logging in through an external identity provider.
+func federatedOnly(methods []AuthMethod) bool {
+	social := false
+	for _, m := range methods {
+		switch m {
+		case AuthPassword:
+			return false
+		case AuthSocial:
+			social = true
+		}
+	}
+	return social
+}
+
+// parseLoginMethods reports the ways of logging in offered by the login page,
+// along with its CSRF token, if any.
+func parseLoginMethods(input []byte) ([]AuthMethod, string, error) {
+	doc, err := html.Parse(bytes.NewReader(input))
+	if err != nil {
+		return nil, "", err
+	}
+	var password, mfa, social bool
+	each(doc, func(n *html.Node) bool {
+		switch {
+		case n.DataAtom == atom.Input && attrVal(n, "type") == "password":
+			password = true
+		case n.DataAtom == atom.Input && attrVal(n, "name") == "otp":
+			mfa = true
+		case n.DataAtom == atom.A && hasClass(n, "social-login"):
+			social = true
+		}
+		return true
+	})
+	var methods []AuthMethod
+	if password {
+		methods = append(methods, AuthPassword)
+	}
+	if mfa {
+		methods = append(methods, AuthMFA)
+	}
+	if social {
+		methods = append(methods, AuthSocial)
+	}
+	var token string // not every login method needs one
+	if lf, err := parseLogin(input); err == nil {
+		token = lf.token
+	}
+	return methods, token, nil
 }
 
 func text(n *html.Node) string {
@@ -293,6 +1403,19 @@ func attrVal(n *html.Node, key string) string {
 	return ""
 }
 
+func hasClass(n *html.Node, class string) bool {
+	for _, c := range strings.Fields(attrVal(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func findByClass(n *html.Node, class string) *html.Node {
+	return find(n, func(n *html.Node) bool { return hasClass(n, class) })
+}
+
 func findByAttr(n *html.Node, key, val string) *html.Node {
 	return find(n, func(n *html.Node) bool {
 		for _, attr := range n.Attr {
@@ -1,7 +1,9 @@
 package opal
 
 import (
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -9,7 +11,7 @@ import (
 func TestParseAmount(t *testing.T) {
 	tests := []struct {
 		in   string
-		want int
+		want Money
 	}{
 		{"$0.00", 0},
 		{"$100.00", 10000},
@@ -28,14 +30,58 @@ func TestParseAmount(t *testing.T) {
 	}
 }
 
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{0, "$0.00"},
+		{5, "$0.05"},
+		{410, "$4.10"},
+		{-410, "-$4.10"},
+		{10000, "$100.00"},
+	}
+	for _, tc := range tests {
+		if got := tc.in.String(); got != tc.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int(tc.in), got, tc.want)
+		}
+	}
+}
+
+func TestMoneyRat(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{0, "0.00"},
+		{410, "4.10"},
+		{-410, "-4.10"},
+		{10005, "100.05"},
+	}
+	for _, tc := range tests {
+		if got := tc.in.Rat().FloatString(2); got != tc.want {
+			t.Errorf("Money(%d).Rat() = %s, want %s", int(tc.in), got, tc.want)
+		}
+	}
+}
+
 func TestParseLogin(t *testing.T) {
-	token, err := parseLogin([]byte(loginPage))
-	if err != nil {
-		t.Fatalf("parseLogin: %v", err)
+	tests := []struct {
+		page string
+		want loginForm
+	}{
+		{loginPage, loginForm{"h_username", "h_password", "CSRFToken", "xxx-yyy-zzz"}},
+		{renamedLoginPage, loginForm{"user_email", "user_secret", "_csrf", "ppp-qqq-rrr"}},
 	}
-	const want = "xxx-yyy-zzz"
-	if token != want {
-		t.Errorf("parsed login token: got %q, want %q", token, want)
+	for _, tc := range tests {
+		lf, err := parseLogin([]byte(tc.page))
+		if err != nil {
+			t.Errorf("parseLogin: %v", err)
+			continue
+		}
+		if *lf != tc.want {
+			t.Errorf("parseLogin(%.40q...) = %+v, want %+v", tc.page, *lf, tc.want)
+		}
 	}
 }
 
@@ -43,6 +89,94 @@ const loginPage = `
 <input value="Log in" type="submit" tabindex="21"></span></div><a title="Forgot your username or password?" href="/login/forgotten" tabindex="22">Forgot your username or password?</a></fieldset><div><input type="hidden" name="CSRFToken" value="xxx-yyy-zzz" tabindex="-1">
 `
 
+const renamedLoginPage = `
+<form action="/login/registeredUserUsernameAndPasswordLogin" method="post"><fieldset>
+<input type="text" name="q" placeholder="Search">
+<label>Email<input type="email" name="user_email"></label><label>Password<input type="password" name="user_secret"></label>
+<input type="hidden" name="_csrf" value="ppp-qqq-rrr"><input value="Log in" type="submit"></fieldset></form>
+`
+
+func TestParseAccountState(t *testing.T) {
+	tests := []struct {
+		page string
+		want error
+	}{
+		{overviewPage, nil},
+		{accountClosedPage, ErrAccountClosed},
+		{accountSuspendedPage, ErrAccountSuspended},
+		{registrationIncompletePage, ErrRegistrationIncomplete},
+		{overviewFAQPage, nil},
+	}
+	for _, tc := range tests {
+		if err := parseAccountState([]byte(tc.page)); !errors.Is(err, tc.want) {
+			t.Errorf("parseAccountState(%.40q...) = %v, want %v", tc.page, err, tc.want)
+		}
+	}
+
+	err := parseAccountState([]byte(registrationIncompletePage))
+	if want := `next step is "Add an Opal card"`; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("parseAccountState of incomplete registration = %v, want hint %s", err, want)
+	}
+}
+
+// overviewFAQPage mentions account problems in body copy, not in a notice.
+const overviewFAQPage = overviewPage + `
+<div class="faq"><h3>What happens if my account is closed?</h3><p>If your account has been closed or your account is suspended, any balance can be refunded.</p>
+<h3>Can I add a card straight away?</h3><p>You will need to complete your registration first.</p></div>
+`
+
+const accountClosedPage = `<html>
+<div class="notice warning"><p>Your Opal account has been closed. Please contact Opal Customer Care on 13 67 25 for assistance.</p></div>
+`
+
+const registrationIncompletePage = `<html>
+<div class="notice"><p>Please complete your registration to start using your account.</p>
+<ol><li class="registration-step complete">Verify your email address</li><li class="registration-step">Add an Opal card</li></ol></div>
+`
+
+const accountSuspendedPage = `<html>
+<div class="notice warning"><p>Your Opal account is suspended. Please contact Opal Customer Care on 13 67 25 for assistance.</p></div>
+`
+
+func TestParseLoginMethods(t *testing.T) {
+	tests := []struct {
+		page      string
+		want      []AuthMethod
+		wantToken string
+	}{
+		{fullLoginPage, []AuthMethod{AuthPassword, AuthSocial}, "xxx-yyy-zzz"},
+		{mfaLoginPage, []AuthMethod{AuthPassword, AuthMFA}, "aaa-bbb-ccc"},
+	}
+	for _, tc := range tests {
+		methods, token, err := parseLoginMethods([]byte(tc.page))
+		if err != nil {
+			t.Errorf("parseLoginMethods: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(methods, tc.want) || token != tc.wantToken {
+			t.Errorf("parseLoginMethods = %v, %q; want %v, %q", methods, token, tc.want, tc.wantToken)
+		}
+	}
+}
+
+const fullLoginPage = `<html>
+<form method="post" action="/login/registeredUserUsernameAndPasswordLogin"><fieldset>
+<label for="h_username">Email or username</label><input type="text" name="h_username" id="h_username" tabindex="19">
+<label for="h_password">Password</label><input type="password" name="h_password" id="h_password" tabindex="20">
+<div><span><input value="Log in" type="submit" tabindex="21"></span></div>
+<div><input type="hidden" name="CSRFToken" value="xxx-yyy-zzz" tabindex="-1"></div>
+</fieldset></form>
+<p>Or <a class="social-login" href="/login/service-nsw">log in with Service NSW</a></p>
+`
+
+const mfaLoginPage = `<html>
+<form method="post" action="/login/registeredUserUsernameAndPasswordLogin"><fieldset>
+<input type="text" name="h_username"><input type="password" name="h_password">
+<label for="otp">Verification code</label><input type="text" name="otp" id="otp">
+<input type="hidden" name="CSRFToken" value="aaa-bbb-ccc">
+</fieldset></form>
+`
+
 func TestParseOverview(t *testing.T) {
 	o, err := parseOverview([]byte(overviewPage))
 	if err != nil {
@@ -52,6 +186,7 @@ func TestParseOverview(t *testing.T) {
 		Cards: []Card{{
 			Name:    "My 31415926535 card",
 			Balance: 7743,
+			Status:  "Active",
 		}},
 	}
 	if !reflect.DeepEqual(o, want) {
@@ -63,6 +198,368 @@ const overviewPage = `<html>
 <table class="dashboard-cards" id="dashboard-active-cards"><caption><span>My Opal cards</span></caption><thead><tr><th>View</th><th>Opal Card</th><th>Type</th><th>Balance</th><th>Status</th></tr></thead><tbody><tr class="alt last"><td class="bl"><input value="0" checked="checked" name="registered_card" class="card-radio-selection" id="card_0" type="radio" tabindex="43"></td><td id="nameCol0"><label for="card_0">My 31415926535 card</label></td><td>Adult</td><td>$77.43</td><td class="br">Active</td></tr></tbody></table>
 `
 
+func TestTotalBalance(t *testing.T) {
+	o, err := parseOverview([]byte(overviewMixedPage))
+	if err != nil {
+		t.Fatalf("parseOverview: %v", err)
+	}
+	if got, want := o.TotalBalance(false), Money(7743+1050+200); got != want {
+		t.Errorf("TotalBalance(false) = %v, want %v", got, want)
+	}
+	if got, want := o.TotalBalance(true), Money(7743+1050); got != want {
+		t.Errorf("TotalBalance(true) = %v, want %v", got, want)
+	}
+}
+
+const overviewMixedPage = `<html>
+<table class="dashboard-cards" id="dashboard-active-cards"><caption><span>My Opal cards</span></caption><thead><tr><th>View</th><th>Opal Card</th><th>Type</th><th>Balance</th><th>Status</th></tr></thead><tbody>
+<tr class="alt"><td class="bl"><input value="0" checked="checked" name="registered_card" class="card-radio-selection" id="card_0" type="radio"></td><td id="nameCol0"><label for="card_0">My 31415926535 card</label></td><td>Adult</td><td>$77.43</td><td class="br">Active</td></tr>
+<tr><td class="bl"><input value="1" name="registered_card" class="card-radio-selection" id="card_1" type="radio"></td><td id="nameCol1"><label for="card_1">Kid's card</label></td><td>Child/Youth</td><td>$10.50</td><td class="br">Active</td></tr>
+<tr class="last"><td class="bl"><input value="2" name="registered_card" class="card-radio-selection" id="card_2" type="radio"></td><td id="nameCol2"><label for="card_2">Lost card</label></td><td>Adult</td><td>$2.00</td><td class="br">Blocked</td></tr>
+</tbody></table>
+`
+
+func TestParseOverviewLowBalance(t *testing.T) {
+	o, err := parseOverview([]byte(overviewLowBalancePage))
+	if err != nil {
+		t.Fatalf("parseOverview: %v", err)
+	}
+	var got []bool
+	for _, c := range o.Cards {
+		got = append(got, c.LowBalanceWarning)
+	}
+	if want := []bool{false, true, true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LowBalanceWarning of cards = %v, want %v", got, want)
+	}
+	if s := o.Cards[1].Status; s != "Active" {
+		t.Errorf("Status of low balance card = %q, want Active", s)
+	}
+}
+
+const overviewLowBalancePage = `<html>
+<table class="dashboard-cards" id="dashboard-active-cards"><caption><span>My Opal cards</span></caption><thead><tr><th>View</th><th>Opal Card</th><th>Type</th><th>Balance</th><th></th><th>Status</th></tr></thead><tbody>
+<tr class="alt"><td class="bl"><input value="0" checked="checked" name="registered_card" class="card-radio-selection" id="card_0" type="radio"></td><td id="nameCol0"><label for="card_0">My 31415926535 card</label></td><td>Adult</td><td>$77.43</td><td></td><td class="br">Active</td></tr>
+<tr class="low-balance"><td class="bl"><input value="1" name="registered_card" class="card-radio-selection" id="card_1" type="radio"></td><td id="nameCol1"><label for="card_1">Kid's card</label></td><td>Child/Youth</td><td>$1.20</td><td><span class="low-balance-warning">Low balance</span></td><td class="br">Active</td></tr>
+<tr class="last low-balance"><td class="bl"><input value="2" name="registered_card" class="card-radio-selection" id="card_2" type="radio"></td><td id="nameCol2"><label for="card_2">Spare card</label></td><td>Adult</td><td>$0.50</td><td></td><td class="br">Active</td></tr>
+</tbody></table>
+`
+
+func TestParseOverviewCardNumbers(t *testing.T) {
+	o, err := parseOverview([]byte(overviewCardNumbersPage))
+	if err != nil {
+		t.Fatalf("parseOverview: %v", err)
+	}
+	want := []Card{
+		{Name: "My card", Balance: 7743, Status: "Active", SerialNumber: "3085 2200 **** 1234", TransitLinkNumber: "**** **** 5678"},
+		{Name: "Kid's card", Balance: 1050, Status: "Active"},
+	}
+	if !reflect.DeepEqual(o.Cards, want) {
+		t.Errorf("parseOverview cards:\n got %+v\nwant %+v", o.Cards, want)
+	}
+}
+
+const overviewCardNumbersPage = `<html>
+<table class="dashboard-cards" id="dashboard-active-cards"><caption><span>My Opal cards</span></caption><thead><tr><th>View</th><th>Opal Card</th><th>Type</th><th>Balance</th><th>Status</th></tr></thead><tbody>
+<tr class="alt"><td class="bl"><input value="0" checked="checked" name="registered_card" class="card-radio-selection" id="card_0" type="radio"></td><td id="nameCol0"><label for="card_0">My card</label><br><span class="card-number">3085 2200 **** 1234</span><br><span class="transit-link-number">**** **** 5678</span></td><td>Adult</td><td>$77.43</td><td class="br">Active</td></tr>
+<tr class="last"><td class="bl"><input value="1" name="registered_card" class="card-radio-selection" id="card_1" type="radio"></td><td id="nameCol1"><label for="card_1">Kid's card</label></td><td>Child/Youth</td><td>$10.50</td><td class="br">Active</td></tr>
+</tbody></table>
+`
+
+func TestParseOverviewPrimary(t *testing.T) {
+	o, err := parseOverview([]byte(overviewPrimaryPage))
+	if err != nil {
+		t.Fatalf("parseOverview: %v", err)
+	}
+	var got []bool
+	for _, c := range o.Cards {
+		got = append(got, c.Primary)
+	}
+	if want := []bool{false, true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Primary of cards = %v, want %v", got, want)
+	}
+	if c := o.Cards[1]; c.Name != "Kid's card" || c.Status != "Active" {
+		t.Errorf("primary card is %+v, want Kid's card with status Active", c)
+	}
+
+	twice := strings.Replace(overviewPrimaryPage, `<tr class="alt">`, `<tr class="alt primary-card">`, 1)
+	o, err = parseOverview([]byte(twice))
+	if err != nil {
+		t.Fatalf("parseOverview of page with two primary cards: %v", err)
+	}
+	got = nil
+	for _, c := range o.Cards {
+		got = append(got, c.Primary)
+	}
+	if want := []bool{true, false}; !reflect.DeepEqual(got, want) {
+		t.Errorf("with two cards marked, Primary of cards = %v, want %v", got, want)
+	}
+}
+
+const overviewPrimaryPage = `<html>
+<table class="dashboard-cards" id="dashboard-active-cards"><caption><span>My Opal cards</span></caption><thead><tr><th>View</th><th>Opal Card</th><th>Type</th><th>Balance</th><th></th><th>Status</th></tr></thead><tbody>
+<tr class="alt"><td class="bl"><input value="0" checked="checked" name="registered_card" class="card-radio-selection" id="card_0" type="radio"></td><td id="nameCol0"><label for="card_0">My card</label></td><td>Adult</td><td>$77.43</td><td></td><td class="br">Active</td></tr>
+<tr class="last"><td class="bl"><input value="1" name="registered_card" class="card-radio-selection" id="card_1" type="radio"></td><td id="nameCol1"><label for="card_1">Kid's card</label></td><td>Child/Youth</td><td>$10.50</td><td><span class="primary-card">Primary card</span></td><td class="br">Active</td></tr>
+</tbody></table>
+`
+
+func TestParseOverviewTravelCredits(t *testing.T) {
+	o, err := parseOverview([]byte(overviewCreditsPage))
+	if err != nil {
+		t.Fatalf("parseOverview: %v", err)
+	}
+	want := []TravelCredit{
+		{Description: "Service disruption credit", Amount: 480, Expires: time.Date(2015, time.December, 31, 0, 0, 0, 0, sydneyZone)},
+		{Description: "Goodwill credit", Amount: 1000},
+	}
+	if !reflect.DeepEqual(o.TravelCredits, want) {
+		t.Errorf("TravelCredits = %+v, want %+v", o.TravelCredits, want)
+	}
+
+	o, err = parseOverview([]byte(overviewPage))
+	if err != nil {
+		t.Fatalf("parseOverview: %v", err)
+	}
+	if o.TravelCredits != nil {
+		t.Errorf("TravelCredits = %+v for page without credits, want none", o.TravelCredits)
+	}
+
+	page := strings.Replace(overviewCreditsPage, "<td>$10.00</td><td></td>", "<td>$10.00</td><td>Expires soon</td>", 1)
+	page = strings.Replace(page, "</tbody></table></div>", "<tr><td>Mystery credit</td><td>Pending</td><td></td></tr>\n</tbody></table></div>", 1)
+	o, err = parseOverview([]byte(page))
+	if err != nil {
+		t.Fatalf("parseOverview with bad credits: %v", err)
+	}
+	want[1].Expires = time.Time{}
+	if !reflect.DeepEqual(o.TravelCredits, want) {
+		t.Errorf("with bad credits, TravelCredits = %+v, want %+v", o.TravelCredits, want)
+	}
+	if len(o.ParseErrors) != 2 || !strings.HasPrefix(o.ParseErrors[0], "bad travel credit expiry ") || !strings.HasPrefix(o.ParseErrors[1], "bad travel credit amount ") {
+		t.Errorf("parseOverview with bad credits has parse errors %q, want a bad expiry and a bad amount", o.ParseErrors)
+	}
+}
+
+const overviewCreditsPage = overviewPage + `
+<div id="travel-credits"><h3>Travel credits</h3><table><thead><tr><th>Credit</th><th>Amount</th><th>Expiry</th></tr></thead><tbody>
+<tr><td>Service disruption credit</td><td>$4.80</td><td>Expires 31/12/2015</td></tr>
+<tr><td>Goodwill credit</td><td>$10.00</td><td></td></tr>
+</tbody></table></div>
+`
+
+func TestParseOverviewDataAsOf(t *testing.T) {
+	o, err := parseOverview([]byte(overviewUpdatedPage))
+	if err != nil {
+		t.Fatalf("parseOverview: %v", err)
+	}
+	if want := time.Date(2015, time.October, 14, 9, 30, 0, 0, sydneyZone); !o.DataAsOf.Equal(want) {
+		t.Errorf("DataAsOf = %v, want %v", o.DataAsOf, want)
+	}
+
+	o, err = parseOverview([]byte(overviewPage))
+	if err != nil {
+		t.Fatalf("parseOverview: %v", err)
+	}
+	if !o.DataAsOf.IsZero() {
+		t.Errorf("DataAsOf = %v for page without timestamp, want zero", o.DataAsOf)
+	}
+
+	o, err = parseOverview([]byte(strings.Replace(overviewUpdatedPage, "14/10/2015", "14/13/2015", 1)))
+	if err != nil {
+		t.Fatalf("parseOverview with bad timestamp: %v", err)
+	}
+	if !o.DataAsOf.IsZero() || len(o.Cards) != 1 {
+		t.Errorf("parseOverview with bad timestamp = %+v, want cards and zero DataAsOf", o)
+	}
+	if want := "bad last updated time "; len(o.ParseErrors) != 1 || !strings.HasPrefix(o.ParseErrors[0], want) {
+		t.Errorf("parseOverview has parse errors %q, want one starting %q", o.ParseErrors, want)
+	}
+}
+
+const overviewUpdatedPage = `<html>
+<p class="balance-updated">Balances last updated: 14/10/2015 09:30</p>
+<table class="dashboard-cards" id="dashboard-active-cards"><caption><span>My Opal cards</span></caption><thead><tr><th>View</th><th>Opal Card</th><th>Type</th><th>Balance</th><th>Status</th></tr></thead><tbody><tr class="alt last"><td class="bl"><input value="0" checked="checked" name="registered_card" class="card-radio-selection" id="card_0" type="radio" tabindex="43"></td><td id="nameCol0"><label for="card_0">My 31415926535 card</label></td><td>Adult</td><td>$77.43</td><td class="br">Active</td></tr></tbody></table>
+`
+
+func TestParseOverviewDisplayName(t *testing.T) {
+	tests := []struct {
+		page, want string
+	}{
+		{overviewPage, ""},
+		{`<p class="welcome">Welcome, <strong>Alice Smith</strong></p>` + overviewPage, "Alice Smith"},
+		{`<p>Welcome, Bob!</p>` + overviewPage, "Bob"},
+	}
+	for _, tc := range tests {
+		o, err := parseOverview([]byte(tc.page))
+		if err != nil {
+			t.Fatalf("parseOverview: %v", err)
+		}
+		if o.DisplayName != tc.want {
+			t.Errorf("DisplayName = %q, want %q", o.DisplayName, tc.want)
+		}
+	}
+}
+
+func TestWeekEnd(t *testing.T) {
+	at := func(d, h, m int) time.Time { return time.Date(2015, time.October, d, h, m, 0, 0, sydneyZone) }
+	tests := []struct {
+		t, want time.Time
+	}{
+		{at(14, 9, 30), at(19, 0, 0)},  // Wednesday
+		{at(18, 23, 59), at(19, 0, 0)}, // Sunday
+		{at(19, 0, 0), at(26, 0, 0)},   // Monday
+		{at(14, 9, 30).UTC(), at(19, 0, 0)},
+	}
+	for _, tc := range tests {
+		if got := weekEnd(tc.t); !got.Equal(tc.want) {
+			t.Errorf("weekEnd(%v) = %v, want %v", tc.t, got, tc.want)
+		}
+	}
+}
+
+func TestParseOverviewWeeklyReward(t *testing.T) {
+	o, err := parseOverview([]byte(overviewRewardPage))
+	if err != nil {
+		t.Fatalf("parseOverview: %v", err)
+	}
+	w := o.WeeklyReward
+	if w == nil {
+		t.Fatalf("parseOverview did not find weekly reward")
+	}
+	if w.Journeys != 6 {
+		t.Errorf("WeeklyReward.Journeys = %d, want 6", w.Journeys)
+	}
+	if tier, ok := w.NextTier(); !ok || tier.Discount != 50 {
+		t.Errorf("WeeklyReward.NextTier() = %+v, %v; want 50%% discount tier", tier, ok)
+	}
+	if got := w.Remaining(); got != 2 {
+		t.Errorf("WeeklyReward.Remaining() = %d, want 2", got)
+	}
+	if got := w.Discount(); got != 0 {
+		t.Errorf("WeeklyReward.Discount() = %d, want 0", got)
+	}
+
+	w.Journeys = 9
+	if _, ok := w.NextTier(); ok {
+		t.Errorf("WeeklyReward.NextTier() after 9 journeys returned a tier")
+	}
+	if got := w.Remaining(); got != 0 {
+		t.Errorf("WeeklyReward.Remaining() after 9 journeys = %d, want 0", got)
+	}
+	if got := w.Discount(); got != 50 {
+		t.Errorf("WeeklyReward.Discount() after 9 journeys = %d, want 50", got)
+	}
+}
+
+func TestParseOverviewBadWeeklyReward(t *testing.T) {
+	page := strings.Replace(overviewRewardPage, "You've made 6 paid journeys this week.", "Weekly Travel Reward reached!", 1)
+	o, err := parseOverview([]byte(page))
+	if err != nil {
+		t.Fatalf("parseOverview with unrecognised weekly reward: %v", err)
+	}
+	if o.WeeklyReward != nil || len(o.Cards) != 1 {
+		t.Errorf("parseOverview with unrecognised weekly reward = %+v, want cards and no weekly reward", o)
+	}
+	if want := "bad weekly reward "; len(o.ParseErrors) != 1 || !strings.HasPrefix(o.ParseErrors[0], want) {
+		t.Errorf("parseOverview has parse errors %q, want one starting %q", o.ParseErrors, want)
+	}
+}
+
+const overviewRewardPage = `<html>
+<div id="weekly-travel-reward"><h3>Weekly Travel Reward</h3><p>You've made 6 paid journeys this week.</p></div>
+<table class="dashboard-cards" id="dashboard-active-cards"><caption><span>My Opal cards</span></caption><thead><tr><th>View</th><th>Opal Card</th><th>Type</th><th>Balance</th><th>Status</th></tr></thead><tbody><tr class="alt last"><td class="bl"><input value="0" checked="checked" name="registered_card" class="card-radio-selection" id="card_0" type="radio" tabindex="43"></td><td id="nameCol0"><label for="card_0">My 31415926535 card</label></td><td>Adult</td><td>$77.43</td><td class="br">Active</td></tr></tbody></table>
+`
+
+func TestParseOverviewCaps(t *testing.T) {
+	o, err := parseOverview([]byte(overviewCapsPage))
+	if err != nil {
+		t.Fatalf("parseOverview: %v", err)
+	}
+	want := &CapStatus{
+		Daily:  CapProgress{Spent: 1580, Cap: 1580},
+		Weekly: CapProgress{Spent: 3020, Cap: 6320},
+		ByMode: map[TransportMode]CapProgress{
+			ModeFerry: {Spent: 520, Cap: 1580},
+		},
+	}
+	if !reflect.DeepEqual(o.Caps, want) {
+		t.Errorf("parseOverview returned incorrect caps.\n got %+v\nwant %+v", o.Caps, want)
+	}
+	if !o.Caps.Daily.Reached() || o.Caps.Weekly.Reached() {
+		t.Errorf("Reached: got daily %v, weekly %v; want true, false", o.Caps.Daily.Reached(), o.Caps.Weekly.Reached())
+	}
+
+	// Unknown caps are skipped.
+	page := strings.Replace(overviewCapsPage, "<tr><th>Ferry cap</th>", "<tr><th>Airport access cap</th><td>Not reached</td></tr>\n<tr><th>Ferry cap</th>", 1)
+	if o, err = parseOverview([]byte(page)); err != nil {
+		t.Fatalf("parseOverview with unknown cap: %v", err)
+	}
+	want.ParseErrors = []string{`unknown cap "Airport access cap"`}
+	if !reflect.DeepEqual(o.Caps, want) {
+		t.Errorf("parseOverview with unknown cap returned incorrect caps.\n got %+v\nwant %+v", o.Caps, want)
+	}
+}
+
+const overviewCapsPage = `<html>
+<table id="fare-caps"><caption><span>Fare caps</span></caption><tbody>
+<tr><th>Daily cap</th><td>$15.80 of $15.80</td></tr>
+<tr><th>Weekly cap</th><td>$30.20 of $63.20</td></tr>
+<tr><th>Ferry cap</th><td>$5.20 of $15.80</td></tr>
+</tbody></table>
+<table class="dashboard-cards" id="dashboard-active-cards"><caption><span>My Opal cards</span></caption><thead><tr><th>View</th><th>Opal Card</th><th>Type</th><th>Balance</th><th>Status</th></tr></thead><tbody><tr class="alt last"><td class="bl"><input value="0" checked="checked" name="registered_card" class="card-radio-selection" id="card_0" type="radio" tabindex="43"></td><td id="nameCol0"><label for="card_0">My 31415926535 card</label></td><td>Adult</td><td>$77.43</td><td class="br">Active</td></tr></tbody></table>
+`
+
+func TestParseAccountSummary(t *testing.T) {
+	as, err := parseAccountSummary([]byte(accountSummaryPage))
+	if err != nil {
+		t.Fatalf("parseAccountSummary: %v", err)
+	}
+	want := &AccountSummary{Cards: 2, TripsThisWeek: 5, SpentThisWeek: 2050}
+	if !reflect.DeepEqual(as, want) {
+		t.Errorf("parseAccountSummary returned incorrect data.\n got %+v\nwant %+v", as, want)
+	}
+
+	if _, err := parseAccountSummary([]byte(overviewPage)); !errors.Is(err, ErrUnknownLayout) {
+		t.Errorf("parseAccountSummary of page without summary: got err %v, want ErrUnknownLayout", err)
+	}
+}
+
+const accountSummaryPage = `<html>
+<dl id="account-summary">
+<dt>Cards</dt><dd>2</dd>
+<dt>Trips this week</dt><dd>5</dd>
+<dt>Spent this week</dt><dd>$20.50</dd>
+</dl>
+`
+
+func TestParseSelectedCard(t *testing.T) {
+	tests := []struct {
+		page   string
+		want   int
+		wantOK bool
+	}{
+		{activityPage, 0, false},
+		{activitySelectorPage, 1, true},
+		{`<select name="cardIndex"><option value="0">My card</option></select>`, 0, false},
+	}
+	for _, tc := range tests {
+		got, ok := parseSelectedCard([]byte(tc.page))
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("parseSelectedCard(%.40q...) = %d, %v, want %d, %v", tc.page, got, ok, tc.want, tc.wantOK)
+		}
+	}
+
+	if _, err := parseActivity([]byte(invalidCardPage)); err != ErrInvalidCardIndex {
+		t.Errorf("parseActivity of invalid card page: got err %v, want ErrInvalidCardIndex", err)
+	}
+}
+
+const activitySelectorPage = `<html>
+<form><select name="cardIndex" id="card-selector"><option value="0">My 31415926535 card</option><option value="1" selected="selected">Kid's card</option></select></form>
+`
+
+const invalidCardPage = `<html>
+<div class="notice warning"><p>Sorry, the card could not be found. Please select a card from the list.</p></div>
+`
+
 func TestParseActivity(t *testing.T) {
 	a, err := parseActivity([]byte(activityPage))
 	if err != nil {
@@ -78,6 +575,7 @@ func TestParseActivity(t *testing.T) {
 				Details:       "Willoughby Rd nr Garland to York St nr Margaret St",
 				JourneyNumber: 6,
 				Fare:          350,
+				BaseFare:      350,
 				Amount:        -350,
 			},
 			{
@@ -87,6 +585,7 @@ func TestParseActivity(t *testing.T) {
 				Details:     "Town Hall to No tap off",
 				FareApplied: "Default fare",
 				Fare:        810,
+				BaseFare:    810,
 				Amount:      -810,
 			},
 			{
@@ -96,6 +595,7 @@ func TestParseActivity(t *testing.T) {
 				Details:       "Chatswood to Town Hall",
 				JourneyNumber: 1,
 				Fare:          410,
+				BaseFare:      410,
 				Amount:        -410,
 			},
 			{
@@ -124,3 +624,387 @@ const activityPage = `<html>
 <tr><td>2</td><td class="date-time">Wed<br/>09/07/2014<br/>07:49</td><td class="center"></td><td class="transaction-summary">Top up - opal.com.au</td><td></td><td class="right"></td><td class="right nowrap"></td><td class="right nowrap"></td><td class="right nowrap">$100.00</td></tr>
 </tbody></table>
 `
+
+func TestParseActivitySurcharge(t *testing.T) {
+	a, err := parseActivity([]byte(activityPageOf(activitySurchargeRow)))
+	if err != nil {
+		t.Fatalf("parseActivity: %v", err)
+	}
+	want := []*Transaction{
+		{
+			Number:        7,
+			When:          time.Date(2015, time.October, 1, 8, 15, 0, 0, sydneyZone),
+			Mode:          "train",
+			Details:       "Domestic Airport to Central",
+			JourneyNumber: 2,
+			Fare:          1780,
+			BaseFare:      410,
+			Surcharge:     1370,
+			Amount:        -1780,
+		},
+	}
+	if !reflect.DeepEqual(a.Transactions, want) {
+		t.Errorf("parseActivity returned incorrect transactions.\n got %+v\nwant %+v", a.Transactions, want)
+	}
+}
+
+const activitySurchargeRow = `<tr><td>7</td><td class="date-time">Thu<br>01/10/2015<br>08:15</td><td class="center"><img alt="train" src="/images/icons/mode-train.png"></td><td class="transaction-summary">Domestic Airport to Central</td><td>2</td><td></td><td class="right nowrap">$4.10<br>+ $13.70 surcharge</td><td class="right nowrap">$0.00</td><td class="right nowrap">-$17.80</td></tr>`
+
+func TestParseActivityOldLayout(t *testing.T) {
+	a, err := parseActivity([]byte(activityOldLayoutPage))
+	if err != nil {
+		t.Fatalf("parseActivity: %v", err)
+	}
+	want := []*Transaction{
+		{
+			Number:        3,
+			When:          time.Date(2014, time.July, 9, 7, 49, 0, 0, sydneyZone),
+			Mode:          "train",
+			Details:       "Chatswood to Town Hall",
+			JourneyNumber: 1,
+			Fare:          410,
+			BaseFare:      410,
+			Amount:        -410,
+		},
+	}
+	if !reflect.DeepEqual(a.Transactions, want) {
+		t.Errorf("parseActivity returned incorrect transactions.\n got %+v\nwant %+v", a.Transactions, want)
+	}
+
+	if _, err := parseActivity([]byte(activityUnknownLayoutPage)); err == nil {
+		t.Errorf("parseActivity of unknown layout succeeded, want error")
+	}
+}
+
+const activityOldLayoutPage = `<html>
+<table id="transaction-data"><caption><span>My Opal activity: 31415926535 is pi</span></caption>
+<thead><tr><th>Transaction<br>number</th><th>Date/time</th><th class="narrow center">Mode</th><th>Details</th><th class="narrow center">Journey<br>number</th><th class="right">Fare</th><th class="right amount">Amount</th></tr></thead>
+<tbody>
+<tr><td>3</td><td class="date-time">Wed<br/>09/07/2014<br/>07:49</td><td class="center"><img height="32" width="32" alt="train" src="/images/icons/mode-train.png"/></td><td class="transaction-summary">Chatswood to Town Hall</td><td>1</td><td class="right nowrap">$4.10</td><td class="right nowrap">-$4.10</td></tr>
+</tbody></table>
+`
+
+const activityUnknownLayoutPage = `<html>
+<table id="transaction-data"><caption><span>My Opal activity: 31415926535 is pi</span></caption>
+<thead><tr><th>When</th><th>What</th><th>How much</th></tr></thead>
+<tbody>
+<tr><td>Wed 09/07/2014 07:49</td><td>Chatswood to Town Hall</td><td>-$4.10</td></tr>
+</tbody></table>
+`
+
+func TestParseActivityNotices(t *testing.T) {
+	a, err := parseActivity([]byte(activityNoticePage))
+	if err != nil {
+		t.Fatalf("parseActivity: %v", err)
+	}
+	want := []Notice{
+		{Level: NoticeWarning, Text: "You've reached your daily cap."},
+		{Level: NoticeInfo, Text: "Travel Reward applied."},
+	}
+	if !reflect.DeepEqual(a.Notices, want) {
+		t.Errorf("parseActivity returned incorrect notices.\n got %+v\nwant %+v", a.Notices, want)
+	}
+}
+
+const activityNoticePage = `<html>
+<div class="notice warning"><p>You've reached your daily cap.</p></div>
+<div class="notice"><p>Travel Reward applied.</p></div>
+<table id="transaction-data"><caption><span>My Opal activity: 31415926535 is pi</span></caption>
+<thead><tr><th>Transaction<br>number</th><th>Date/time</th><th class="narrow center">Mode</th><th>Details</th><th class="narrow center">Journey<br>number</th><th>Fare Applied</th><th class="right">Fare</th><th class="right amount">Discount</th><th class="right amount">Amount</th></tr></thead>
+<tbody>
+<tr><td>3</td><td class="date-time">Wed<br/>09/07/2014<br/>07:49</td><td class="center"><img height="32" width="32" alt="train" src="/images/icons/mode-train.png"/></td><td class="transaction-summary">Chatswood to Town Hall</td><td>1</td><td class="right"></td><td class="right nowrap">$4.10</td><td class="right nowrap">$0.00</td><td class="right nowrap">-$4.10</td></tr>
+</tbody></table>
+`
+
+func TestParseActivityJSON(t *testing.T) {
+	a, err := parseActivityJSON([]byte(activityJSON))
+	if err != nil {
+		t.Fatalf("parseActivityJSON: %v", err)
+	}
+	want := &Activity{
+		CardName: "31415926535 is pi",
+		Transactions: []*Transaction{
+			{
+				Number:        6,
+				When:          time.Date(2015, time.September, 29, 7, 47, 0, 0, sydneyZone),
+				Mode:          "bus",
+				Details:       "Willoughby Rd nr Garland to York St nr Margaret St",
+				JourneyNumber: 6,
+				Fare:          350,
+				BaseFare:      350,
+				Amount:        -350,
+			},
+			{
+				Number:  2,
+				When:    time.Date(2014, time.July, 9, 7, 49, 0, 0, sydneyZone),
+				Details: "Top up - opal.com.au",
+				Amount:  10000,
+			},
+		},
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("parseActivityJSON returned incorrect data.\n got %+v\nwant %+v", a, want)
+	}
+}
+
+const activityJSON = `{
+	"cardName": "31415926535 is pi",
+	"transactions": [
+		{"number": 6, "dateTime": "Tue 29/09/2015 07:47", "mode": "bus", "details": "Willoughby Rd nr Garland to York St nr Margaret St", "journeyNumber": 6, "fareApplied": "", "fare": "$3.50", "discount": "$0.00", "amount": "-$3.50"},
+		{"number": 2, "dateTime": "Wed 09/07/2014 07:49", "mode": "", "details": "Top up - opal.com.au", "fareApplied": "", "fare": "", "discount": "", "amount": "$100.00"}
+	]
+}`
+
+func TestParseConcessionStatus(t *testing.T) {
+	cs, err := parseConcessionStatus([]byte(concessionDetailsPage))
+	if err != nil {
+		t.Fatalf("parseConcessionStatus: %v", err)
+	}
+	want := &ConcessionStatus{
+		Verified:   true,
+		ReverifyBy: time.Date(2015, time.December, 31, 0, 0, 0, 0, sydneyZone),
+	}
+	if !reflect.DeepEqual(cs, want) {
+		t.Errorf("parseConcessionStatus returned incorrect data.\n got %+v\nwant %+v", cs, want)
+	}
+
+	if _, err := parseConcessionStatus([]byte(adultDetailsPage)); err != ErrNotConcession {
+		t.Errorf("parseConcessionStatus on an adult card: got err %v, want ErrNotConcession", err)
+	}
+}
+
+func TestParseCardDetails(t *testing.T) {
+	cd, err := parseCardDetails([]byte(concessionDetailsPage))
+	if err != nil {
+		t.Fatalf("parseCardDetails: %v", err)
+	}
+	want := &CardDetails{
+		Name:        "My 31415926535 card",
+		Type:        "Concession",
+		ActivatedAt: time.Date(2014, time.March, 3, 0, 0, 0, 0, sydneyZone),
+	}
+	if !reflect.DeepEqual(cd, want) {
+		t.Errorf("parseCardDetails returned incorrect data.\n got %+v\nwant %+v", cd, want)
+	}
+
+	cd, err = parseCardDetails([]byte(adultDetailsPage))
+	if err != nil {
+		t.Fatalf("parseCardDetails: %v", err)
+	}
+	if !cd.ActivatedAt.IsZero() {
+		t.Errorf("ActivatedAt = %v for page without activation date, want zero", cd.ActivatedAt)
+	}
+}
+
+func TestParseCardDetailsAutoTopUp(t *testing.T) {
+	cd, err := parseCardDetails([]byte(autoTopUpFailedPage))
+	if err != nil {
+		t.Fatalf("parseCardDetails: %v", err)
+	}
+	want := &AutoTopUpSettings{
+		Enabled: true,
+		Amount:  4000,
+		LastFailure: &TopUpFailure{
+			When:   time.Date(2015, time.October, 12, 0, 0, 0, 0, sydneyZone),
+			Reason: "your payment card was declined",
+		},
+	}
+	if !reflect.DeepEqual(cd.AutoTopUp, want) {
+		t.Errorf("parseCardDetails returned incorrect auto top up.\n got %+v\nwant %+v", cd.AutoTopUp, want)
+	}
+
+	cd, err = parseCardDetails([]byte(adultDetailsPage))
+	if err != nil {
+		t.Fatalf("parseCardDetails: %v", err)
+	}
+	if want := (&AutoTopUpSettings{}); !reflect.DeepEqual(cd.AutoTopUp, want) {
+		t.Errorf("parseCardDetails returned incorrect auto top up.\n got %+v\nwant %+v", cd.AutoTopUp, want)
+	}
+
+	cd, err = parseCardDetails([]byte(autoTopUpUnavailablePage))
+	if err != nil {
+		t.Fatalf("parseCardDetails: %v", err)
+	}
+	if want := (&AutoTopUpSettings{UnavailableReason: "No payment method is registered."}); !reflect.DeepEqual(cd.AutoTopUp, want) {
+		t.Errorf("parseCardDetails returned incorrect auto top up.\n got %+v\nwant %+v", cd.AutoTopUp, want)
+	}
+}
+
+const autoTopUpUnavailablePage = `<html>
+<table id="card-details"><caption><span>Opal card details</span></caption><tbody>
+<tr><th>Card name</th><td>My 31415926535 card</td></tr>
+<tr><th>Card type</th><td>Adult</td></tr>
+<tr><th>Auto top up</th><td>Unavailable <span class="auto-top-up-reason">No payment method is registered.</span></td></tr>
+</tbody></table>
+`
+
+const autoTopUpFailedPage = `<html>
+<div class="notice warning"><p>Your auto top up on 12/10/2015 failed: your payment card was declined. Please update your payment details.</p></div>
+<table id="card-details"><caption><span>Opal card details</span></caption><tbody>
+<tr><th>Card name</th><td>My 31415926535 card</td></tr>
+<tr><th>Card type</th><td>Adult</td></tr>
+<tr><th>Auto top up</th><td>On</td></tr>
+<tr><th>Auto top up amount</th><td>$40.00</td></tr>
+</tbody></table>
+`
+
+const concessionDetailsPage = `<html>
+<table id="card-details"><caption><span>Opal card details</span></caption><tbody>
+<tr><th>Card name</th><td>My 31415926535 card</td></tr>
+<tr><th>Card type</th><td>Concession</td></tr>
+<tr><th>Activated</th><td>03/03/2014</td></tr>
+<tr><th>Concession status</th><td>Verified</td></tr>
+<tr><th>Re-verify by</th><td>31/12/2015</td></tr>
+</tbody></table>
+`
+
+const adultDetailsPage = `<html>
+<table id="card-details"><caption><span>Opal card details</span></caption><tbody>
+<tr><th>Card name</th><td>My 31415926535 card</td></tr>
+<tr><th>Card type</th><td>Adult</td></tr>
+<tr><th>Auto top up</th><td>Off</td></tr>
+</tbody></table>
+`
+
+func TestParseActivityMalformedFare(t *testing.T) {
+	bad := strings.Replace(activityRow3, "<td class=\"right nowrap\">$4.10</td>", "<td class=\"right nowrap\">$4.1O</td>", 1)
+	a, err := parseActivity([]byte(activityPageOf(activityRow6, bad)))
+	if err != nil {
+		t.Fatalf("parseActivity: %v", err)
+	}
+	if len(a.Transactions) != 2 {
+		t.Fatalf("parseActivity returned %d transactions, want 2", len(a.Transactions))
+	}
+	if pe := a.Transactions[0].ParseErrors; pe != nil {
+		t.Errorf("well-formed transaction has parse errors %q", pe)
+	}
+	tr := a.Transactions[1]
+	if want := `bad fare "$4.1O": `; len(tr.ParseErrors) != 1 || !strings.HasPrefix(tr.ParseErrors[0], want) {
+		t.Errorf("malformed transaction has parse errors %q, want one starting %q", tr.ParseErrors, want)
+	}
+	if tr.Fare != 0 || tr.Amount != -410 || tr.Details != "Chatswood to Town Hall" {
+		t.Errorf("malformed transaction is %v, want zero fare and other fields parsed", tr)
+	}
+}
+
+func TestParseActivityMalformedDate(t *testing.T) {
+	bad := strings.Replace(activityRow3, "Wed<br/>09/07/2014<br/>07:49", "Wed<br/>2014-07-09<br/>07:49", 1)
+	page := []byte(activityPageOf(activityRow6, bad, activityRow5))
+	if _, err := parseActivity(page); err == nil {
+		t.Errorf("strict parseActivity of malformed date succeeded, want error")
+	}
+
+	a, err := parseActivityWith(page, true)
+	if err != nil {
+		t.Fatalf("lenient parseActivity: %v", err)
+	}
+	if len(a.Transactions) != 3 {
+		t.Fatalf("lenient parseActivity returned %d transactions, want 3", len(a.Transactions))
+	}
+	for _, i := range []int{0, 2} {
+		if tr := a.Transactions[i]; tr.When.IsZero() || tr.ParseErrors != nil {
+			t.Errorf("well-formed transaction %d is %v with parse errors %q", i, tr, tr.ParseErrors)
+		}
+	}
+	tr := a.Transactions[1]
+	if !tr.When.IsZero() {
+		t.Errorf("malformed transaction has time %v, want zero", tr.When)
+	}
+	if want := "bad time "; len(tr.ParseErrors) != 1 || !strings.HasPrefix(tr.ParseErrors[0], want) {
+		t.Errorf("malformed transaction has parse errors %q, want one starting %q", tr.ParseErrors, want)
+	}
+	if tr.Amount != -410 || tr.Details != "Chatswood to Town Hall" {
+		t.Errorf("malformed transaction is %v, want other fields parsed", tr)
+	}
+}
+
+func TestParseActivityMalformedTapOff(t *testing.T) {
+	bad := strings.Replace(activityRow3, "07:49</td>", "23:50 - 25:99</td>", 1)
+	page := []byte(activityPageOf(activityRow6, bad))
+	if _, err := parseActivity(page); err == nil {
+		t.Errorf("strict parseActivity of malformed tap off time succeeded, want error")
+	}
+
+	a, err := parseActivityWith(page, true)
+	if err != nil {
+		t.Fatalf("lenient parseActivity: %v", err)
+	}
+	tr := a.Transactions[1]
+	if want := time.Date(2014, time.July, 9, 23, 50, 0, 0, sydneyZone); !tr.When.Equal(want) || !tr.TapOff.IsZero() {
+		t.Errorf("malformed transaction has times %v and %v, want %v and zero", tr.When, tr.TapOff, want)
+	}
+	if want := "bad tap off time "; len(tr.ParseErrors) != 1 || !strings.HasPrefix(tr.ParseErrors[0], want) {
+		t.Errorf("malformed transaction has parse errors %q, want one starting %q", tr.ParseErrors, want)
+	}
+}
+
+func TestParseActivityUnavailable(t *testing.T) {
+	if _, err := parseActivity([]byte(activityUnavailablePage)); err != ErrActivityUnavailable {
+		t.Errorf("parseActivity: got err %v, want ErrActivityUnavailable", err)
+	}
+}
+
+func TestParseActivityHelpText(t *testing.T) {
+	tests := []struct {
+		name, help string
+	}{
+		{"unavailable", `<div class="help"><p>If your travel history is temporarily unavailable, try again tomorrow.</p></div>`},
+		{"invalid card", `<div class="help"><p>Unknown card selected? Choose another card from the list above.</p></div>`},
+	}
+	for _, tc := range tests {
+		a, err := parseActivity([]byte(activityPageOf(activityRow6, activityRow3) + tc.help))
+		if err != nil {
+			t.Errorf("%s: parseActivity with help text: %v", tc.name, err)
+			continue
+		}
+		if len(a.Transactions) != 2 {
+			t.Errorf("%s: parseActivity with help text returned %d transactions, want 2", tc.name, len(a.Transactions))
+		}
+	}
+}
+
+const activityUnavailablePage = `<html>
+<h2>My Opal activity</h2>
+<div class="notice"><p>Your Opal activity is currently unavailable. Please check back later.</p></div>
+`
+
+func TestParseActivityIndex(t *testing.T) {
+	refs, err := parseActivityIndex([]byte(activityIndexPage))
+	if err != nil {
+		t.Fatalf("parseActivityIndex: %v", err)
+	}
+	want := []ActivityPeriodRef{
+		{
+			Offset: 0,
+			Label:  "01/10/2015 - 14/10/2015",
+			Start:  time.Date(2015, time.October, 1, 0, 0, 0, 0, sydneyZone),
+			End:    time.Date(2015, time.October, 14, 0, 0, 0, 0, sydneyZone),
+		},
+		{
+			Offset: 1,
+			Label:  "17/09/2015 - 30/09/2015",
+			Start:  time.Date(2015, time.September, 17, 0, 0, 0, 0, sydneyZone),
+			End:    time.Date(2015, time.September, 30, 0, 0, 0, 0, sydneyZone),
+		},
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("parseActivityIndex returned incorrect data.\n got %+v\nwant %+v", refs, want)
+	}
+
+	refs, err = parseActivityIndex([]byte(activityPage))
+	if err != nil || len(refs) != 0 {
+		t.Errorf("parseActivityIndex of page without selector = %v, %v; want no periods", refs, err)
+	}
+
+	bad := strings.Replace(activityIndexPage, "17/09/2015 - 30/09/2015", "last month", 1)
+	if _, err := parseActivityIndex([]byte(bad)); err == nil {
+		t.Errorf("parseActivityIndex of bad period succeeded, want error")
+	}
+}
+
+const activityIndexPage = `<html>
+<form><label for="transaction-period">Show activity for</label><select id="transaction-period" name="pageIndex">
+<option value="0" selected="selected">01/10/2015 - 14/10/2015</option>
+<option value="1">17/09/2015 - 30/09/2015</option>
+</select></form>
+`
@@ -0,0 +1,168 @@
+package opal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// WithRecorder makes the client record each HTTP exchange it makes to a file in dir,
+// so that the session can later be replayed with WithReplayer.
+// Form values and cookie values are redacted before being written, but response
+// bodies are recorded verbatim and will contain personal information.
+func WithRecorder(dir string) Option {
+	return func(c *Client) {
+		next := c.hc.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		c.hc.Transport = &recorder{dir: dir, next: next}
+	}
+}
+
+// WithReplayer makes the client replay a session recorded by WithRecorder
+// instead of talking to the Opal site.
+// Requests must be made in the same order as they were recorded.
+func WithReplayer(dir string) Option {
+	return func(c *Client) {
+		c.hc.Transport = &replayer{dir: dir}
+	}
+}
+
+// exchange is a single recorded HTTP request and its response.
+type exchange struct {
+	Method string
+	URL    string
+	Form   url.Values `json:",omitempty"`
+
+	StatusCode int
+	Header     http.Header
+	Body       string
+}
+
+const redacted = "REDACTED"
+
+func exchangeFile(dir string, n int) string {
+	return filepath.Join(dir, fmt.Sprintf("%03d.json", n))
+}
+
+type recorder struct {
+	dir  string
+	next http.RoundTripper
+
+	mu sync.Mutex
+	n  int // number of exchanges recorded
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	ex := &exchange{
+		Method: req.Method,
+		URL:    req.URL.String(),
+	}
+	if req.Body != nil {
+		raw, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(raw))
+		if form, err := url.ParseQuery(string(raw)); err == nil && len(form) > 0 {
+			for k := range form {
+				form[k] = []string{redacted}
+			}
+			ex.Form = form
+		}
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(raw))
+
+	ex.StatusCode = resp.StatusCode
+	ex.Header = resp.Header.Clone()
+	for i, sc := range ex.Header["Set-Cookie"] {
+		ex.Header["Set-Cookie"][i] = redactCookie(sc)
+	}
+	ex.Body = string(raw)
+
+	out, err := json.MarshalIndent(ex, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := os.MkdirAll(r.dir, 0700); err != nil {
+		return nil, err
+	}
+	r.n++
+	if err := ioutil.WriteFile(exchangeFile(r.dir, r.n), out, 0600); err != nil {
+		return nil, fmt.Errorf("recording exchange: %v", err)
+	}
+	return resp, nil
+}
+
+// redactCookie replaces the value in a Set-Cookie header.
+func redactCookie(sc string) string {
+	nv, attrs := sc, ""
+	if i := strings.Index(sc, ";"); i >= 0 {
+		nv, attrs = sc[:i], sc[i:]
+	}
+	if i := strings.Index(nv, "="); i >= 0 {
+		nv = nv[:i+1] + redacted
+	}
+	return nv + attrs
+}
+
+type replayer struct {
+	dir string
+
+	mu sync.Mutex
+	n  int // number of exchanges replayed
+}
+
+func (r *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	r.mu.Lock()
+	r.n++
+	n := r.n
+	r.mu.Unlock()
+
+	raw, err := ioutil.ReadFile(exchangeFile(r.dir, n))
+	if err != nil {
+		return nil, fmt.Errorf("replaying exchange %d: %v", n, err)
+	}
+	ex := new(exchange)
+	if err := json.Unmarshal(raw, ex); err != nil {
+		return nil, fmt.Errorf("bad recorded exchange %d: %v", n, err)
+	}
+	if req.Method != ex.Method || req.URL.String() != ex.URL {
+		return nil, fmt.Errorf("replaying exchange %d: request is %s %s, but recorded %s %s", n, req.Method, req.URL, ex.Method, ex.URL)
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", ex.StatusCode, http.StatusText(ex.StatusCode)),
+		StatusCode: ex.StatusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     ex.Header,
+		Body:       ioutil.NopCloser(strings.NewReader(ex.Body)),
+		Request:    req,
+	}, nil
+}
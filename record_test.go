@@ -0,0 +1,119 @@
+package opal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3kr1t", Path: "/"})
+		io.WriteString(w, "page "+r.URL.Path)
+	}))
+	dir := t.TempDir()
+	paths := []string{"/registered/one", "/registered/two"}
+
+	c := newTestClient(t, srv, WithRecorder(dir))
+	for _, path := range paths {
+		if _, err := c.FetchAuthenticated(context.Background(), path); err != nil {
+			t.Fatalf("recording FetchAuthenticated(%q): %v", path, err)
+		}
+	}
+	srv.Close()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(paths) {
+		t.Fatalf("recorded %d files, want %d", len(files), len(paths))
+	}
+	for _, f := range files {
+		raw, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(raw), "s3kr1t") {
+			t.Errorf("%s contains unredacted cookie value", f)
+		}
+	}
+
+	c = newTestClient(t, srv, WithReplayer(dir))
+	for _, path := range paths {
+		body, err := c.FetchAuthenticated(context.Background(), path)
+		if err != nil {
+			t.Fatalf("replaying FetchAuthenticated(%q): %v", path, err)
+		}
+		if want := "page " + path; string(body) != want {
+			t.Errorf("replayed body = %q, want %q", body, want)
+		}
+	}
+	if _, err := c.FetchAuthenticated(context.Background(), "/registered/three"); err == nil {
+		t.Errorf("replaying past the end of the recording succeeded, want error")
+	}
+}
+
+func TestRecordLoginRedacted(t *testing.T) {
+	_, srv := newFakeOpal(map[string]string{"/registered/index": overviewPage})
+	defer srv.Close()
+	dir := t.TempDir()
+	c := newTestClient(t, srv, WithRecorder(dir))
+	if _, err := c.Overview(); err != nil {
+		t.Fatalf("c.Overview: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sawLogin, sawCookie bool
+	for _, f := range files {
+		raw, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ex exchange
+		if err := json.Unmarshal(raw, &ex); err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		if strings.Contains(string(raw), "secret") {
+			t.Errorf("%s contains the password", f)
+		}
+		if ex.Method == "POST" {
+			sawLogin = true
+			if got := ex.Form.Get("h_password"); got != redacted {
+				t.Errorf("%s records password form value %q, want %q", f, got, redacted)
+			}
+		}
+		for _, sc := range ex.Header["Set-Cookie"] {
+			sawCookie = true
+			if !strings.HasPrefix(sc, "session="+redacted) {
+				t.Errorf("%s records unredacted cookie %q", f, sc)
+			}
+		}
+	}
+	if !sawLogin || !sawCookie {
+		t.Errorf("recording has login POST %v and Set-Cookie %v, want both", sawLogin, sawCookie)
+	}
+}
+
+func TestRedactCookie(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"session=abc", "session=REDACTED"},
+		{"session=abc; Path=/; HttpOnly", "session=REDACTED; Path=/; HttpOnly"},
+	}
+	for _, tc := range tests {
+		if got := redactCookie(tc.in); got != tc.want {
+			t.Errorf("redactCookie(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package opal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// RetryPolicy controls how the client retries requests that fail transiently.
+// Only GET requests are retried; POSTs, such as logging in, are never repeated blindly.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a request is made, including the first.
+	// Values below 1 mean 1.
+	MaxAttempts int
+	// RetryStatus lists the HTTP status codes that are worth retrying.
+	RetryStatus []int
+	// RetryNetworkErrors is whether to retry requests that got no response at all.
+	RetryNetworkErrors bool
+	// Backoff is the wait before the second attempt.
+	// It doubles for each attempt after that.
+	Backoff time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy used unless WithRetryPolicy is given.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:        3,
+	RetryStatus:        []int{502, 503, 504},
+	RetryNetworkErrors: true,
+	Backoff:            500 * time.Millisecond,
+}
+
+// WithRetryPolicy sets the policy for retrying requests that fail transiently.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// An OperationError is returned when fetching a page fails.
+// It records the effort spent before giving up.
+type OperationError struct {
+	Attempts int   // HTTP requests made for the page, across all retries
+	ReLogins int   // times the client logged in again part way through
+	Err      error // the final error
+}
+
+func (e *OperationError) Error() string {
+	if e.Attempts <= 1 && e.ReLogins == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v (after %d attempts, %d re-logins)", e.Err, e.Attempts, e.ReLogins)
+}
+
+func (e *OperationError) Unwrap() error { return e.Err }
+
+// retriable reports whether a request that ended with the given
+// status code (if it got a response) or error is worth retrying.
+func (p RetryPolicy) retriable(method string, status int, err error) bool {
+	if method != "GET" {
+		return false
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false
+		}
+		var ne net.Error
+		return p.RetryNetworkErrors && errors.As(err, &ne)
+	}
+	for _, s := range p.RetryStatus {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// attempts returns the maximum number of attempts to make.
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// backoff returns how long to wait after the given attempt fails.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	return p.Backoff << uint(attempt-1)
+}
@@ -0,0 +1,115 @@
+package opal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyRetriable(t *testing.T) {
+	netErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	tests := []struct {
+		method string
+		status int
+		err    error
+		want   bool
+	}{
+		{"GET", 200, nil, false},
+		{"GET", 404, nil, false},
+		{"GET", 503, nil, true},
+		{"GET", 0, netErr, true},
+		{"GET", 0, errRedirect, false},
+		{"GET", 0, context.DeadlineExceeded, false},
+		{"POST", 503, nil, false},
+		{"POST", 0, netErr, false},
+	}
+	for _, tc := range tests {
+		if got := DefaultRetryPolicy.retriable(tc.method, tc.status, tc.err); got != tc.want {
+			t.Errorf("DefaultRetryPolicy.retriable(%q, %d, %v) = %v, want %v", tc.method, tc.status, tc.err, got, tc.want)
+		}
+	}
+
+	noNet := DefaultRetryPolicy
+	noNet.RetryNetworkErrors = false
+	if noNet.retriable("GET", 0, netErr) {
+		t.Errorf("policy without RetryNetworkErrors retries network errors")
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+	}
+	for _, tc := range tests {
+		if got := p.backoff(tc.attempt); got != tc.want {
+			t.Errorf("backoff(%d) = %v, want %v", tc.attempt, got, tc.want)
+		}
+	}
+	if got := (RetryPolicy{}).attempts(); got != 1 {
+		t.Errorf("zero RetryPolicy makes %d attempts, want 1", got)
+	}
+}
+
+func TestRetryPolicyApplied(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if hits < 3 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	policy := RetryPolicy{MaxAttempts: 3, RetryStatus: []int{503}, Backoff: time.Millisecond}
+	c := newTestClient(t, srv, WithRetryPolicy(policy))
+	if _, err := c.FetchAuthenticated(context.Background(), "/"); err != nil {
+		t.Fatalf("FetchAuthenticated: %v", err)
+	}
+	if hits != 3 {
+		t.Errorf("server was hit %d times, want 3", hits)
+	}
+
+	hits = 0
+	policy.MaxAttempts = 2
+	c = newTestClient(t, srv, WithRetryPolicy(policy))
+	if _, err := c.FetchAuthenticated(context.Background(), "/"); err == nil {
+		t.Errorf("FetchAuthenticated succeeded after %d attempts, want error", hits)
+	}
+	if hits != 2 {
+		t.Errorf("server was hit %d times, want 2", hits)
+	}
+}
+
+func TestOperationError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	policy := RetryPolicy{MaxAttempts: 3, RetryStatus: []int{503}, Backoff: time.Millisecond}
+	c := newTestClient(t, srv, WithRetryPolicy(policy))
+	_, err := c.FetchAuthenticated(context.Background(), "/")
+	var oe *OperationError
+	if !errors.As(err, &oe) {
+		t.Fatalf("FetchAuthenticated returned %v, want an *OperationError", err)
+	}
+	if oe.Attempts != 3 || oe.ReLogins != 0 {
+		t.Errorf("OperationError records %d attempts and %d re-logins, want 3 and 0", oe.Attempts, oe.ReLogins)
+	}
+	if errors.Unwrap(err) != oe.Err {
+		t.Errorf("errors.Unwrap(%v) = %v, want %v", err, errors.Unwrap(err), oe.Err)
+	}
+}
@@ -0,0 +1,187 @@
+package opal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SessionValid reports whether the client's session cookies are still accepted
+// by the Opal site. Unlike other methods, it does not log in if they are not.
+// It returns an error if the site's response shows neither.
+func (c *Client) SessionValid(ctx context.Context) (bool, error) {
+	resp, _, err := c.getWithRetry(ctx, c.resolve(c.overviewPath, nil))
+	if errors.Is(err, errRedirect) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	// Anything else, such as a server error, says nothing about the session.
+	if resp.StatusCode != 200 {
+		return false, fmt.Errorf("HTTP response %s", resp.Status)
+	}
+	return true, nil
+}
+
+// Logout ends the client's session with the Opal site.
+// It finds the logout form or link on the overview page,
+// and confirms afterwards that the session is no longer accepted.
+func (c *Client) Logout() error {
+	ctx := context.Background()
+	body, err := c.get(ctx, c.resolve(c.overviewPath, nil))
+	if err != nil {
+		return err
+	}
+	la, err := parseLogout(body)
+	if err != nil {
+		return err
+	}
+	ref, err := url.Parse(la.action)
+	if err != nil {
+		return fmt.Errorf("bad logout URL %q: %v", la.action, err)
+	}
+	u := c.base.ResolveReference(ref)
+	var req *http.Request
+	if la.method == "POST" {
+		req, err = http.NewRequestWithContext(ctx, "POST", u.String(), strings.NewReader(la.form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		if len(la.form) > 0 {
+			u.RawQuery = la.form.Encode()
+		}
+		req, err = http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	// Being redirected, such as to the login page, is the expected outcome.
+	// Where to doesn't matter, since the check below confirms the logout.
+	redirected := resp != nil && resp.StatusCode/100 == 3
+	if err != nil && !redirected {
+		return fmt.Errorf("logging out: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 && !redirected {
+		return fmt.Errorf("logging out: HTTP response %s", resp.Status)
+	}
+
+	valid, err := c.SessionValid(ctx)
+	if err != nil {
+		return fmt.Errorf("checking logout: %w", err)
+	}
+	if valid {
+		return errors.New("session still valid after logging out")
+	}
+	return nil
+}
+
+// SessionState is the state of the client's session with the Opal site.
+type SessionState int
+
+const (
+	SessionUnknown SessionState = iota
+	SessionActive
+	SessionExpired
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case SessionActive:
+		return "active"
+	case SessionExpired:
+		return "expired"
+	}
+	return "unknown"
+}
+
+// A SessionEvent reports a change in the state of the client's session.
+type SessionEvent struct {
+	From, To SessionState
+	ReLogin  bool  // whether To was reached by logging in again
+	Err      error // set if checking the session or logging in failed
+}
+
+// A SessionMonitor periodically checks a client's session,
+// logging in again when it has expired.
+type SessionMonitor struct {
+	c        *Client
+	interval time.Duration
+	events   chan SessionEvent
+}
+
+// NewSessionMonitor returns a SessionMonitor that checks c's session every interval.
+// It does nothing until Run is called.
+func NewSessionMonitor(c *Client, interval time.Duration) *SessionMonitor {
+	return &SessionMonitor{
+		c:        c,
+		interval: interval,
+		events:   make(chan SessionEvent),
+	}
+}
+
+// Events returns the channel on which changes in session state are sent.
+// It is closed when Run returns.
+func (m *SessionMonitor) Events() <-chan SessionEvent { return m.events }
+
+// Run checks the session immediately and then every interval, until ctx is done.
+func (m *SessionMonitor) Run(ctx context.Context) {
+	defer close(m.events)
+	state := SessionUnknown
+	send := func(ev SessionEvent) bool {
+		select {
+		case m.events <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	tick, stop := m.c.clock.NewTicker(m.interval)
+	defer stop()
+	for {
+		valid, err := m.c.SessionValid(ctx)
+		switch {
+		case err != nil:
+			if ctx.Err() == nil && !send(SessionEvent{From: state, To: state, Err: err}) {
+				return
+			}
+		case valid && state != SessionActive:
+			if !send(SessionEvent{From: state, To: SessionActive}) {
+				return
+			}
+			state = SessionActive
+		case !valid:
+			if state != SessionExpired {
+				if !send(SessionEvent{From: state, To: SessionExpired}) {
+					return
+				}
+				state = SessionExpired
+			}
+			if err := m.c.login(ctx); err != nil {
+				if ctx.Err() == nil && !send(SessionEvent{From: state, To: state, Err: err}) {
+					return
+				}
+			} else {
+				if !send(SessionEvent{From: state, To: SessionActive, ReLogin: true}) {
+					return
+				}
+				state = SessionActive
+			}
+		}
+
+		select {
+		case <-tick:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package opal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionValid(t *testing.T) {
+	_, srv := newFakeOpal(map[string]string{"/registered/index": overviewPage})
+	defer srv.Close()
+	c := newTestClient(t, srv)
+	ctx := context.Background()
+
+	if valid, err := c.SessionValid(ctx); err != nil || valid {
+		t.Errorf("before login, c.SessionValid = %v, %v; want false, nil", valid, err)
+	}
+	if _, err := c.Overview(); err != nil {
+		t.Fatalf("c.Overview: %v", err)
+	}
+	if valid, err := c.SessionValid(ctx); err != nil || !valid {
+		t.Errorf("after login, c.SessionValid = %v, %v; want true, nil", valid, err)
+	}
+}
+
+func TestSessionValidServerError(t *testing.T) {
+	for _, status := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "down", status)
+		}))
+		c := newTestClient(t, srv, WithRetryPolicy(RetryPolicy{}))
+		if valid, err := c.SessionValid(context.Background()); err == nil {
+			t.Errorf("with HTTP status %d, c.SessionValid = %v, nil; want error", status, valid)
+		}
+		srv.Close()
+	}
+}
+
+func TestSessionMonitor(t *testing.T) {
+	f, srv := newFakeOpal(map[string]string{"/registered/index": overviewPage})
+	defer srv.Close()
+	c := newTestClient(t, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewSessionMonitor(c, 10*time.Millisecond)
+	go m.Run(ctx)
+
+	want := []SessionEvent{
+		{From: SessionUnknown, To: SessionExpired},
+		{From: SessionExpired, To: SessionActive, ReLogin: true},
+	}
+	for _, w := range want {
+		ev := <-m.Events()
+		if ev != w {
+			t.Errorf("got event %+v, want %+v", ev, w)
+		}
+	}
+	cancel()
+	for ev := range m.Events() {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if n := f.loginCount(); n != 1 {
+		t.Errorf("monitor logged in %d times, want 1", n)
+	}
+}
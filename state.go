@@ -0,0 +1,28 @@
+package opal
+
+import "time"
+
+// ClientState is a snapshot of what a Client is doing.
+type ClientState struct {
+	InFlight       int // requests awaiting a response
+	MaxConcurrency int // the limit set by WithMaxConcurrency, or zero if there is none
+
+	// RetryIn is how long until a fetch that failed transiently is next retried,
+	// or zero if no fetch is waiting to retry.
+	RetryIn time.Duration
+}
+
+// State reports what the client is doing, without making any requests.
+// It is safe to call concurrently with other methods.
+func (c *Client) State() ClientState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := ClientState{
+		InFlight:       c.inFlight,
+		MaxConcurrency: cap(c.sem),
+	}
+	if d := time.Until(c.retryUntil); d > 0 {
+		st.RetryIn = d
+	}
+	return st
+}
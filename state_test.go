@@ -0,0 +1,57 @@
+package opal
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestState(t *testing.T) {
+	respond := make(chan bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !<-respond {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+	policy := RetryPolicy{MaxAttempts: 2, RetryStatus: []int{503}, Backoff: time.Hour}
+	c := newTestClient(t, srv, WithMaxConcurrency(3), WithRetryPolicy(policy))
+
+	if st := c.State(); st != (ClientState{MaxConcurrency: 3}) {
+		t.Errorf("idle client has state %+v", st)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		_, err := c.FetchAuthenticated(ctx, "/")
+		done <- err
+	}()
+	waitFor(t, func() bool { return c.State().InFlight == 1 })
+
+	// Fail the request, so the client waits to retry.
+	respond <- false
+	waitFor(t, func() bool { st := c.State(); return st.InFlight == 0 && st.RetryIn > 59*time.Minute })
+
+	cancel()
+	<-done
+	if st := c.State(); st.RetryIn != 0 {
+		t.Errorf("after giving up, client has state %+v", st)
+	}
+}
+
+// waitFor waits for cond to become true, failing the test if it takes too long.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for start := time.Now(); !cond(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("condition not met after 5s")
+		}
+	}
+}
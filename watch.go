@@ -0,0 +1,95 @@
+package opal
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// An OverviewEvent reports a change seen by WatchOverview.
+type OverviewEvent struct {
+	Overview *Overview    // the overview that was fetched, if fetching succeeded
+	Changes  []CardChange // how the cards differ from the previous overview
+	Err      error        // set if fetching the overview failed
+}
+
+// A CardChange is a difference in a card between two overviews.
+// Cards are matched by name. Old is nil for a card that has appeared,
+// and New is nil for one that has gone.
+type CardChange struct {
+	Old, New *Card
+}
+
+// diffCards returns the changes from the cards in o to those in n.
+func diffCards(o, n []Card) []CardChange {
+	var changes []CardChange
+	old := make(map[string]*Card)
+	for i := range o {
+		old[o[i].Name] = &o[i]
+	}
+	for i := range n {
+		c := &n[i]
+		oc, ok := old[c.Name]
+		delete(old, c.Name)
+		if !ok || *oc != *c {
+			changes = append(changes, CardChange{Old: oc, New: c})
+		}
+	}
+	for i := range o { // keep the order of the old overview
+		if oc, ok := old[o[i].Name]; ok {
+			changes = append(changes, CardChange{Old: oc})
+		}
+	}
+	return changes
+}
+
+// WatchOverview fetches the overview every interval and sends an event
+// each time its cards change, such as in their balance or status.
+// The first overview is fetched before WatchOverview returns,
+// and sent as an event in which every card is new.
+// Failures to fetch later overviews are sent as events with Err set.
+// The channel is closed once ctx is done.
+func (c *Client) WatchOverview(ctx context.Context, interval time.Duration) (<-chan OverviewEvent, error) {
+	if interval <= 0 {
+		return nil, errors.New("non-positive interval")
+	}
+	prev, _, err := c.overview(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan OverviewEvent, 1)
+	ch <- OverviewEvent{Overview: prev, Changes: diffCards(nil, prev.Cards)}
+
+	go func() {
+		defer close(ch)
+		tick, stop := c.clock.NewTicker(interval)
+		defer stop()
+		for {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				return
+			}
+			var ev OverviewEvent
+			o, _, err := c.overview(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				ev.Err = err
+			} else {
+				ev.Overview, ev.Changes = o, diffCards(prev.Cards, o.Cards)
+				prev = o
+				if len(ev.Changes) == 0 {
+					continue
+				}
+			}
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
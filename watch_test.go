@@ -0,0 +1,100 @@
+package opal
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock whose tickers tick only when the test says so.
+type fakeClock struct {
+	now  time.Time
+	tick chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, tick: make(chan time.Time)}
+}
+
+func (fc *fakeClock) Now() time.Time { return fc.now }
+
+func (fc *fakeClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	return fc.tick, func() {}
+}
+
+func TestDiffCards(t *testing.T) {
+	a := Card{Name: "A", Balance: 100, Status: "Active"}
+	b := Card{Name: "B", Balance: 200, Status: "Active"}
+	spent, blocked := a, b
+	spent.Balance = 50
+	blocked.Status = "Blocked"
+	c := Card{Name: "C", Balance: 300}
+
+	tests := []struct {
+		old, new []Card
+		want     []CardChange
+	}{
+		{[]Card{a, b}, []Card{a, b}, nil},
+		{nil, []Card{a}, []CardChange{{nil, &a}}},
+		{[]Card{a, b}, []Card{spent, blocked}, []CardChange{{&a, &spent}, {&b, &blocked}}},
+		{[]Card{a, b}, []Card{a, c}, []CardChange{{nil, &c}, {&b, nil}}},
+	}
+	for _, tc := range tests {
+		got := diffCards(tc.old, tc.new)
+		if len(got) != len(tc.want) {
+			t.Errorf("diffCards(%v, %v) returned %d changes, want %d", tc.old, tc.new, len(got), len(tc.want))
+			continue
+		}
+		for i, w := range tc.want {
+			if !sameCard(got[i].Old, w.Old) || !sameCard(got[i].New, w.New) {
+				t.Errorf("diffCards(%v, %v) change %d = {%v %v}, want {%v %v}", tc.old, tc.new, i, got[i].Old, got[i].New, w.Old, w.New)
+			}
+		}
+	}
+}
+
+func sameCard(a, b *Card) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func TestWatchOverview(t *testing.T) {
+	f, srv := newFakeOpal(map[string]string{"/registered/index": overviewPage})
+	defer srv.Close()
+	c := newTestClient(t, srv)
+	fc := newFakeClock(time.Now())
+	c.clock = fc
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.WatchOverview(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("c.WatchOverview: %v", err)
+	}
+	ev := <-events
+	if ev.Err != nil || len(ev.Changes) != 1 || ev.Changes[0].Old != nil {
+		t.Errorf("first event is %+v, want the card as new", ev)
+	}
+
+	// Nothing changes, so the next event is for the top up after the second tick.
+	fc.tick <- time.Now()
+	f.mu.Lock()
+	f.pages["/registered/index"] = strings.Replace(overviewPage, "$77.43", "$97.43", 1)
+	f.mu.Unlock()
+	fc.tick <- time.Now()
+	ev = <-events
+	if ev.Err != nil || len(ev.Changes) != 1 {
+		t.Fatalf("event after top up is %+v, want one change", ev)
+	}
+	if ch := ev.Changes[0]; ch.Old.Balance != 7743 || ch.New.Balance != 9743 {
+		t.Errorf("balance changed from %v to %v, want $77.43 to $97.43", ch.Old.Balance, ch.New.Balance)
+	}
+
+	cancel()
+	for ev := range events {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}